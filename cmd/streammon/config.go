@@ -0,0 +1,411 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/fitzy101/streammon/internal/stream"
+)
+
+// cfgArgList holds the args of a stream in the config file, which are either
+// a string to split into arguments, or a JSON array of the arguments as is.
+type cfgArgList struct {
+	str  string
+	list []string
+}
+
+// UnmarshalJSON accepts either a JSON string or an array of strings.
+func (c *cfgArgList) UnmarshalJSON(b []byte) error {
+	if err := json.Unmarshal(b, &c.str); err == nil {
+		return nil
+	}
+	c.list = []string{}
+	return json.Unmarshal(b, &c.list)
+}
+
+// defaultName returns the name for the numbered stream when the config
+// doesn't give it one.
+func defaultName(i int) string {
+	return fmt.Sprintf("stream-%v", i+1)
+}
+
+// defaultJitter is the fraction of a retry's backoff that's randomised when
+// the config file doesn't set one.
+const defaultJitter = 0.2
+
+// cfgAction holds a command or one of the built-in actions in the config
+// file.
+type cfgAction struct {
+	Command string     `json:"command"`
+	Args    cfgArgList `json:"args"`
+	Shell   bool       `json:"shell"`
+	HTTP    *cfgHTTP   `json:"http"`
+	File    *cfgFile   `json:"file"`
+	Redis   *cfgRedis  `json:"redis"`
+	Syslog  *cfgSyslog `json:"syslog"`
+}
+
+// options validates an action in a stream's list of actions and returns it
+// as stream.ActionOptions. It must have exactly one command or action.
+func (c *cfgAction) options() (stream.ActionOptions, error) {
+	opts := stream.ActionOptions{Command: c.Command, Shell: c.Shell}
+	n := 0
+
+	var err error
+	if c.Command != "" {
+		n++
+		if opts.Args, err = commandArgs(c.Args.str, c.Shell); err != nil {
+			return opts, err
+		}
+		if c.Args.list != nil {
+			opts.Args = c.Args.list
+		}
+	}
+	if c.HTTP != nil {
+		n++
+		if opts.HTTP, err = c.HTTP.options(); err != nil {
+			return opts, err
+		}
+	}
+	if c.File != nil {
+		n++
+		if opts.File, err = c.File.options(); err != nil {
+			return opts, err
+		}
+	}
+	if c.Redis != nil {
+		n++
+		if opts.Redis, err = c.Redis.options(); err != nil {
+			return opts, err
+		}
+	}
+	if c.Syslog != nil {
+		n++
+		if opts.Syslog, err = c.Syslog.options(); err != nil {
+			return opts, err
+		}
+	}
+
+	if n == 0 {
+		return opts, errors.New(errCommand)
+	}
+	if n > 1 {
+		return opts, errors.New(errAction)
+	}
+	return opts, nil
+}
+
+// cfgExec holds a command a stream reads its lines from in the config
+// file.
+type cfgExec struct {
+	Command    string     `json:"command"`
+	Args       cfgArgList `json:"args"`
+	Stderr     bool       `json:"stderr"`
+	Backoff    string     `json:"backoff"`
+	MaxBackoff string     `json:"max_backoff"`
+	Once       bool       `json:"once"`
+}
+
+// source validates the exec config and returns it as a stream.ExecSource.
+func (c *cfgExec) source() (*stream.ExecSource, error) {
+	src := stream.ExecSource{
+		Command: c.Command,
+		Stderr:  c.Stderr,
+		Once:    c.Once,
+	}
+	if c.Command == "" {
+		return nil, errors.New(errExec)
+	}
+
+	var err error
+	if src.Args, err = splitArgs(c.Args.str); err != nil {
+		return nil, err
+	}
+	if c.Args.list != nil {
+		src.Args = c.Args.list
+	}
+	if c.Backoff != "" {
+		if src.Backoff, err = time.ParseDuration(c.Backoff); err != nil {
+			return nil, err
+		}
+	}
+	if c.MaxBackoff != "" {
+		if src.MaxBackoff, err = time.ParseDuration(c.MaxBackoff); err != nil {
+			return nil, err
+		}
+	}
+	return &src, nil
+}
+
+// cfgIngest holds the settings of an http:// source in the config file.
+type cfgIngest struct {
+	Token    string `json:"token"`
+	MaxBytes int64  `json:"max_bytes"`
+}
+
+// options validates the ingest config and returns it as
+// stream.IngestOptions.
+func (c *cfgIngest) options() (stream.IngestOptions, error) {
+	if c.MaxBytes < 0 {
+		return stream.IngestOptions{}, errors.New(errConfigInvalid)
+	}
+	return stream.IngestOptions{Token: c.Token, MaxBytes: c.MaxBytes}, nil
+}
+
+// cfgTimestamp holds how a stream in the config file reads the time each
+// event occurred.
+type cfgTimestamp struct {
+	Layout   string `json:"layout"`
+	Pattern  string `json:"pattern"`
+	Field    string `json:"field"`
+	Location string `json:"location"`
+}
+
+// options validates the timestamp config and returns it as
+// stream.TimestampOptions.
+func (c *cfgTimestamp) options() (stream.TimestampOptions, error) {
+	opts := stream.TimestampOptions{
+		Layout:   c.Layout,
+		Pattern:  c.Pattern,
+		Field:    c.Field,
+		Location: c.Location,
+	}
+	if c.Layout == "" {
+		return opts, errors.New(errConfigInvalid)
+	}
+	if c.Location != "" {
+		if _, err := time.LoadLocation(c.Location); err != nil {
+			return opts, err
+		}
+	}
+	return opts, nil
+}
+
+// cfgRetry holds the retry policy of a stream in the config file.
+type cfgRetry struct {
+	Attempts   int      `json:"attempts"`
+	Backoff    string   `json:"backoff"`
+	MaxBackoff string   `json:"max_backoff"`
+	Jitter     *float64 `json:"jitter"`
+	ExitCodes  []int    `json:"exit_codes"`
+}
+
+// policy validates the retry config and returns it as a stream.Retry.
+func (c *cfgRetry) policy() (stream.Retry, error) {
+	r := stream.Retry{
+		Attempts:  c.Attempts,
+		Jitter:    defaultJitter,
+		ExitCodes: c.ExitCodes,
+	}
+	if c.Attempts < 0 {
+		return r, errors.New("retry attempts can't be negative")
+	}
+	if c.Jitter != nil {
+		r.Jitter = *c.Jitter
+	}
+	if r.Jitter < 0 || r.Jitter > 1 {
+		return r, errors.New("retry jitter must be between 0 and 1")
+	}
+
+	var err error
+	if c.Backoff != "" {
+		if r.Backoff, err = time.ParseDuration(c.Backoff); err != nil {
+			return r, err
+		}
+	}
+	if c.MaxBackoff != "" {
+		if r.MaxBackoff, err = time.ParseDuration(c.MaxBackoff); err != nil {
+			return r, err
+		}
+	}
+	return r, nil
+}
+
+// cfgHTTP holds an HTTP action in the config file.
+type cfgHTTP struct {
+	Method       string            `json:"method"`
+	URL          string            `json:"url"`
+	Headers      map[string]string `json:"headers"`
+	Body         string            `json:"body"`
+	Timeout      string            `json:"timeout"`
+	SuccessCodes []int             `json:"success_codes"`
+	TLS          struct {
+		InsecureSkipVerify bool   `json:"insecure_skip_verify"`
+		CAFile             string `json:"ca_file"`
+		CertFile           string `json:"cert_file"`
+		KeyFile            string `json:"key_file"`
+	} `json:"tls"`
+}
+
+// options validates the HTTP config and returns it as stream.HTTPOptions.
+func (c *cfgHTTP) options() (*stream.HTTPOptions, error) {
+	opts := stream.HTTPOptions{
+		Method:             c.Method,
+		URL:                c.URL,
+		Headers:            c.Headers,
+		Body:               c.Body,
+		SuccessCodes:       c.SuccessCodes,
+		InsecureSkipVerify: c.TLS.InsecureSkipVerify,
+		CAFile:             c.TLS.CAFile,
+		CertFile:           c.TLS.CertFile,
+		KeyFile:            c.TLS.KeyFile,
+	}
+	if c.URL == "" {
+		return nil, errors.New("an http action needs a url")
+	}
+
+	var err error
+	if c.Timeout != "" {
+		if opts.Timeout, err = time.ParseDuration(c.Timeout); err != nil {
+			return nil, err
+		}
+	}
+	return &opts, nil
+}
+
+// cfgFile holds a file action in the config file.
+type cfgFile struct {
+	Path       string `json:"path"`
+	Format     string `json:"format"`
+	MaxSize    int64  `json:"max_size"`
+	MaxBackups int    `json:"max_backups"`
+	Fsync      string `json:"fsync"`
+}
+
+// options validates the file config and returns it as stream.FileOptions.
+// The fsync policy is "always", "never" (the default) or an interval.
+func (c *cfgFile) options() (*stream.FileOptions, error) {
+	opts := stream.FileOptions{
+		Path:       c.Path,
+		Format:     c.Format,
+		MaxSize:    c.MaxSize,
+		MaxBackups: c.MaxBackups,
+	}
+	if c.Path == "" {
+		return nil, errors.New("a file action needs a path")
+	}
+	if c.MaxSize < 0 || c.MaxBackups < 0 {
+		return nil, errors.New("file max_size and max_backups can't be negative")
+	}
+
+	switch c.Fsync {
+	case "", "never":
+	case "always":
+		opts.SyncAlways = true
+	default:
+		interval, err := time.ParseDuration(c.Fsync)
+		if err != nil || interval <= 0 {
+			return nil, errors.New("file fsync must be always, never or an interval")
+		}
+		opts.SyncInterval = interval
+	}
+	return &opts, nil
+}
+
+// cfgRedis holds a redis action in the config file.
+type cfgRedis struct {
+	Addr     string            `json:"addr"`
+	Password string            `json:"password"`
+	DB       int               `json:"db"`
+	Command  string            `json:"command"`
+	Key      string            `json:"key"`
+	Value    string            `json:"value"`
+	Fields   map[string]string `json:"fields"`
+	PoolSize int               `json:"pool_size"`
+	Timeout  string            `json:"timeout"`
+}
+
+// options validates the redis config and returns it as stream.RedisOptions.
+func (c *cfgRedis) options() (*stream.RedisOptions, error) {
+	opts := stream.RedisOptions{
+		Addr:     c.Addr,
+		Password: c.Password,
+		DB:       c.DB,
+		Command:  strings.ToUpper(c.Command),
+		Key:      c.Key,
+		Value:    c.Value,
+		Fields:   c.Fields,
+		PoolSize: c.PoolSize,
+	}
+	switch opts.Command {
+	case "PUBLISH", "LPUSH", "XADD", "INCR":
+	default:
+		return nil, errors.New("a redis action's command must be one of PUBLISH, LPUSH, XADD or INCR")
+	}
+	if c.Key == "" {
+		return nil, errors.New("a redis action needs a key")
+	}
+	if c.DB < 0 || c.PoolSize < 0 {
+		return nil, errors.New("redis db and pool_size can't be negative")
+	}
+
+	var err error
+	if c.Timeout != "" {
+		if opts.Timeout, err = time.ParseDuration(c.Timeout); err != nil {
+			return nil, err
+		}
+	}
+	return &opts, nil
+}
+
+// cfgSyslog holds a syslog action in the config file.
+type cfgSyslog struct {
+	Network        string            `json:"network"`
+	Addr           string            `json:"addr"`
+	Facility       string            `json:"facility"`
+	Severity       string            `json:"severity"`
+	Tag            string            `json:"tag"`
+	Hostname       string            `json:"hostname"`
+	MsgID          string            `json:"msg_id"`
+	Message        string            `json:"message"`
+	StructuredData map[string]string `json:"structured_data"`
+	SDID           string            `json:"sd_id"`
+	Timeout        string            `json:"timeout"`
+}
+
+// options validates the syslog config and returns it as
+// stream.SyslogOptions.
+func (c *cfgSyslog) options() (*stream.SyslogOptions, error) {
+	opts := stream.SyslogOptions{
+		Network:        c.Network,
+		Addr:           c.Addr,
+		Facility:       c.Facility,
+		Severity:       c.Severity,
+		Tag:            c.Tag,
+		Hostname:       c.Hostname,
+		MsgID:          c.MsgID,
+		Message:        c.Message,
+		StructuredData: c.StructuredData,
+		SDID:           c.SDID,
+	}
+	switch c.Network {
+	case "":
+	case "udp", "tcp", "unix":
+		if c.Addr == "" {
+			return nil, errors.New("a syslog action needs an addr for its network")
+		}
+	default:
+		return nil, errors.New("a syslog action's network must be udp, tcp or unix")
+	}
+
+	var err error
+	if c.Facility != "" {
+		if _, err = stream.ParseFacility(c.Facility); err != nil {
+			return nil, err
+		}
+	}
+	if c.Severity != "" {
+		if _, err = stream.ParseSeverity(c.Severity); err != nil {
+			return nil, err
+		}
+	}
+	if c.Timeout != "" {
+		if opts.Timeout, err = time.ParseDuration(c.Timeout); err != nil {
+			return nil, err
+		}
+	}
+	return &opts, nil
+}
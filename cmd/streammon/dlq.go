@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/fitzy101/streammon/internal/stream"
+)
+
+const (
+	dreplayDLQ = "run the commands in a dead-letter file again, keeping those that still fail."
+)
+
+var (
+	errReplayArgs = "replay-dlq needs a config file and a dead-letter file"
+)
+
+// replayDLQ implements the replay-dlq subcommand. Each record in the
+// dead-letter file is executed again by the stream in the config file with
+// the same rule name, and the file is rewritten with the records that still
+// failed.
+func replayDLQ(argv []string) error {
+	fs := flag.NewFlagSet("replay-dlq", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	fs.StringVar(&config, "config", "", dconfig)
+	fs.StringVar(&config, "k", "", dconfig)
+	fs.BoolVar(&log, "l", false, dlog)
+	if err := fs.Parse(argv); err != nil || fs.NArg() != 1 || !isCfgFile(config) {
+		return errors.New(errReplayArgs + "\n" + usage())
+	}
+	if log {
+		stream.LogDebug = true
+	}
+
+	streams, err := getStreams(config, "", "", "", "", "", false)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		for _, s := range streams {
+			s.Wait()
+		}
+	}()
+
+	path := fs.Arg(0)
+	recs, err := stream.ReadDeadLetters(path)
+	if err != nil {
+		return err
+	}
+
+	failed := replay(streams, recs)
+	fmt.Fprintf(os.Stdout, "replayed %v dead letters, %v still failed.\n", len(recs)-len(failed), len(failed))
+	return stream.WriteDeadLetters(path, failed)
+}
+
+// replay executes each record with its rule's stream, returning the records
+// that failed again.
+func replay(streams []*stream.Stream, recs []stream.DeadLetter) []stream.DeadLetter {
+	rules := map[string]*stream.Stream{}
+	for _, s := range streams {
+		rules[s.Name()] = s
+	}
+
+	failed := []stream.DeadLetter{}
+	for _, rec := range recs {
+		s, ok := rules[rec.Rule]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "no stream in the config for rule %s, skipping.\n", rec.Rule)
+			failed = append(failed, rec)
+			continue
+		}
+
+		ev := stream.Event{Line: rec.Line, Fields: rec.Fields, Time: rec.Time}
+		if err := s.ExecActions(ev, rec.Actions); err != nil {
+			fmt.Fprintf(os.Stderr, "error exec command %s: \n", err.Error())
+			failed = append(failed, s.DeadLetter(ev, rec.Matched, err))
+		}
+	}
+	return failed
+}
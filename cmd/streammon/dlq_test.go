@@ -0,0 +1,77 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/fitzy101/streammon/internal/stream"
+)
+
+func TestReplayDLQ(t *testing.T) {
+	dir, err := ioutil.TempDir("", "streammon")
+	if err != nil {
+		t.Fatalf("got error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	defer func() {
+		config = ""
+	}()
+
+	out := path.Join(dir, "out")
+	cfg := path.Join(dir, "streammon.conf")
+	contents := []byte(`[
+		{
+			"name":"save",
+			"filepath":"/var/log/messages",
+			"delimiter":" ",
+			"regexp":".*",
+			"command":"sh",
+			"args":["-c", "echo #{2} >> ` + out + `"]
+		},
+		{
+			"filepath":"/var/log/messages",
+			"regexp":".*",
+			"command":"false"
+		}
+	]`)
+	if err := ioutil.WriteFile(cfg, contents, 0644); err != nil {
+		t.Fatalf("got error writing config: %v", err)
+	}
+
+	dlqPath := path.Join(dir, "dlq.jsonl")
+	err = stream.WriteDeadLetters(dlqPath, []stream.DeadLetter{
+		{Rule: "save", Line: "ERROR first"},
+		{Rule: "stream-2", Line: "ERROR second"},
+		{Rule: "gone", Line: "ERROR third"},
+		{Rule: "save", Line: "ERROR fourth"},
+	})
+	if err != nil {
+		t.Fatalf("got error writing dead letters: %v", err)
+	}
+
+	if err := replayDLQ([]string{"-k", cfg, dlqPath}); err != nil {
+		t.Fatalf("got error replaying dead letters: %v", err)
+	}
+
+	b, _ := ioutil.ReadFile(out)
+	if string(b) != "first\nfourth\n" {
+		t.Errorf("expected the save rule to run twice, got %q", string(b))
+	}
+
+	recs, err := stream.ReadDeadLetters(dlqPath)
+	if err != nil {
+		t.Fatalf("got error reading dead letters: %v", err)
+	}
+	if len(recs) != 2 || recs[0].Rule != "stream-2" || recs[1].Rule != "gone" {
+		t.Errorf("expected the failed dead letters to be kept, got %+v", recs)
+	}
+	if recs[0].ExitCode != 1 {
+		t.Errorf("expected the new exit code to be recorded, got %+v", recs[0])
+	}
+
+	if err := replayDLQ([]string{dlqPath}); err == nil {
+		t.Errorf("No error returned without a config, expected %v", errReplayArgs)
+	}
+}
@@ -3,18 +3,17 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"os"
 	re "regexp"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/fitzy101/streammon/internal/stream"
 )
@@ -25,18 +24,30 @@ var (
 	regexp    string
 	command   string
 	cargs     string
+	shell     bool
+	jobs      int
+	dlq       string
 	log       bool
 	config    string
+	maxLine   int
+	longLines string
+	pollEvery time.Duration
 )
 
 const (
-	dfilepath  = "a full path to a file to monitor."
+	dfilepath  = "a full path to a file, glob or directory to monitor, a udp:// or tcp:// address to receive syslog on, a unix:// or unixgram:// socket to listen on, an http:// address and path to accept POSTed lines on, or exec: and a command to read the output of."
 	ddelimiter = "a delimiter to split a matching line."
 	dregexp    = "a regular expression to match."
 	dcommand   = "a command to run after a match is found."
 	dargs      = "a quoted string of arguments to the command."
+	dshell     = "run the command and arguments through /bin/sh -c."
+	djobs      = "the most commands to run at once across all streams, 0 for no limit."
+	ddlq       = "a file to append matched lines to when their command fails."
 	dlog       = "an option to turn on log output"
 	dconfig    = "a configuration file to read from, all other flags are ignored."
+	dmaxLine   = "the longest line in bytes that's read whole, 0 for 1MiB."
+	dpoll      = "how often files that are polled rather than watched with inotify are checked, eg. 2s, 250ms by default."
+	dlongLines = "what to do with longer lines, one of truncate, split or skip."
 	// dtimeout   = "a timeout to wait before running the command."
 )
 
@@ -48,8 +59,22 @@ func usage() string {
 	sbuff.WriteString(fmt.Sprintf("\t\t-r/--regexp %s\n", dregexp))
 	sbuff.WriteString(fmt.Sprintf("\t\t-c/--command %s\n", dcommand))
 	sbuff.WriteString(fmt.Sprintf("\t\t-a/--args %s\n", dargs))
+	sbuff.WriteString(fmt.Sprintf("\t\t-s/--shell %s\n", dshell))
 	sbuff.WriteString(fmt.Sprintf("\t\t-k/--config %s\n", dconfig))
+	sbuff.WriteString(fmt.Sprintf("\t\t-j/--jobs %s\n", djobs))
+	sbuff.WriteString(fmt.Sprintf("\t\t--dlq %s\n", ddlq))
+	sbuff.WriteString(fmt.Sprintf("\t\t--max-line %s\n", dmaxLine))
+	sbuff.WriteString(fmt.Sprintf("\t\t--long-lines %s\n", dlongLines))
+	sbuff.WriteString(fmt.Sprintf("\t\t--poll-interval %s\n", dpoll))
 	sbuff.WriteString(fmt.Sprintf("\t\t-l %s\n", dlog))
+	sbuff.WriteString("\n")
+	sbuff.WriteString("       streammon replay -k CONFIG [--speed 10x] [--dry-run] [--dlq FILE]\n")
+	sbuff.WriteString(fmt.Sprintf("\t\t%s\n", dreplay))
+	sbuff.WriteString(fmt.Sprintf("\t\t--speed %s\n", dspeed))
+	sbuff.WriteString(fmt.Sprintf("\t\t--dry-run %s\n", ddryRun))
+	sbuff.WriteString("\n")
+	sbuff.WriteString("       streammon replay-dlq -k CONFIG FILE\n")
+	sbuff.WriteString(fmt.Sprintf("\t\t%s\n", dreplayDLQ))
 	return sbuff.String()
 }
 
@@ -75,6 +100,24 @@ func init() {
 	flag.StringVar(&cargs, "args", "", dargs)
 	flag.StringVar(&cargs, "a", "", dargs)
 
+	// --shell, -s
+	flag.BoolVar(&shell, "shell", false, dshell)
+	flag.BoolVar(&shell, "s", false, dshell)
+
+	// --jobs, -j
+	flag.IntVar(&jobs, "jobs", 0, djobs)
+	flag.IntVar(&jobs, "j", 0, djobs)
+
+	// --dlq
+	flag.StringVar(&dlq, "dlq", "", ddlq)
+
+	// --max-line, --long-lines
+	flag.IntVar(&maxLine, "max-line", 0, dmaxLine)
+	flag.StringVar(&longLines, "long-lines", "truncate", dlongLines)
+
+	// --poll-interval
+	flag.DurationVar(&pollEvery, "poll-interval", 0, dpoll)
+
 	// -l
 	flag.BoolVar(&log, "l", false, dlog)
 
@@ -94,6 +137,7 @@ type streamArgs struct {
 	regexp    string
 	command   string
 	args      []string
+	opts      stream.Options
 
 	// timeout   int
 }
@@ -122,11 +166,34 @@ func parseConfigFile(cFile []byte) ([]streamArgs, error) {
 	}
 
 	type cfgArgs struct {
-		Filepath  string `json:"filepath"`
-		Delimiter string `json:"delimiter"`
-		Regexp    string `json:"regexp"`
-		Command   string `json:"command"`
-		Args      string `json:"args"`
+		Name      string            `json:"name"`
+		Filepath  string            `json:"filepath"`
+		Delimiter string            `json:"delimiter"`
+		Regexp    string            `json:"regexp"`
+		Exec      *cfgExec          `json:"exec"`
+		Ingest    *cfgIngest        `json:"ingest"`
+		Timestamp *cfgTimestamp     `json:"timestamp"`
+		Scan      string            `json:"scan_interval"`
+		Drain     string            `json:"drain"`
+		Once      bool              `json:"once"`
+		Format    string            `json:"format"`
+		Encoding  string            `json:"encoding"`
+		Invalid   string            `json:"invalid"`
+		Match     map[string]string `json:"match"`
+		Watch     string            `json:"watch"`
+		MaxLine   int               `json:"max_line"`
+		LongLines string            `json:"long_lines"`
+		cfgAction
+		Actions   []cfgAction `json:"actions"`
+		Parallel  bool        `json:"parallel"`
+		OnFailure []cfgAction `json:"on_failure"`
+		Workers   int         `json:"workers"`
+		Queue     int         `json:"queue"`
+		Overflow  string      `json:"overflow"`
+		OrderKey  string      `json:"order_key"`
+		Retry     *cfgRetry   `json:"retry"`
+		OutLimit  int         `json:"output_limit"`
+		OutLog    string      `json:"output_log"`
 	}
 	allConf := make([]cfgArgs, 0)
 
@@ -134,16 +201,144 @@ func parseConfigFile(cFile []byte) ([]streamArgs, error) {
 		return resp, errors.New(errConfig)
 	}
 
-	for _, c := range allConf {
-		arg, err := constructArgs(
+	names := map[string]bool{}
+	for i, c := range allConf {
+		arg, err := newStreamArgs(
 			c.Filepath,
 			c.Delimiter,
 			c.Regexp,
 			c.Command,
-			c.Args)
+			c.Args.str,
+			c.Shell)
 		if err != nil {
 			return resp, errors.New(errConfigInvalid)
 		}
+		if c.Args.list != nil {
+			arg.args = c.Args.list
+		}
+
+		if c.Exec != nil {
+			if c.Filepath != "" {
+				return resp, errors.New(errConfigInvalid)
+			}
+			if arg.opts.Exec, err = c.Exec.source(); err != nil {
+				return resp, errors.New(errConfigInvalid)
+			}
+			arg.filepath = arg.opts.Exec.String()
+		}
+
+		if c.Ingest != nil {
+			if !strings.HasPrefix(c.Filepath, "http://") {
+				return resp, errors.New(errConfigInvalid)
+			}
+			if arg.opts.Ingest, err = c.Ingest.options(); err != nil {
+				return resp, errors.New(errConfigInvalid)
+			}
+		}
+
+		if c.Timestamp != nil {
+			if arg.opts.Timestamp, err = c.Timestamp.options(); err != nil {
+				return resp, errors.New(errConfigInvalid)
+			}
+		}
+
+		if c.Scan != "" {
+			if arg.opts.ScanInterval, err = time.ParseDuration(c.Scan); err != nil {
+				return resp, errors.New(errConfigInvalid)
+			}
+		}
+		if c.Drain != "" {
+			if arg.opts.Drain, err = time.ParseDuration(c.Drain); err != nil {
+				return resp, errors.New(errConfigInvalid)
+			}
+		}
+		arg.opts.Once = c.Once
+		arg.opts.Match = c.Match
+		if arg.opts.Format, err = stream.ParseFormat(c.Format); err != nil {
+			return resp, errors.New(errConfigInvalid)
+		}
+		if arg.opts.Encoding, err = stream.ParseEncoding(c.Encoding); err != nil {
+			return resp, errors.New(errConfigInvalid)
+		}
+		if arg.opts.Invalid, err = stream.ParseInvalid(c.Invalid); err != nil {
+			return resp, errors.New(errConfigInvalid)
+		}
+		if arg.opts.Watch, err = stream.ParseWatch(c.Watch); err != nil {
+			return resp, errors.New(errConfigInvalid)
+		}
+
+		if c.MaxLine < 0 {
+			return resp, errors.New(errConfigInvalid)
+		}
+		arg.opts.MaxLine = c.MaxLine
+		if arg.opts.LongLines, err = stream.ParseLongLines(c.LongLines); err != nil {
+			return resp, errors.New(errConfigInvalid)
+		}
+
+		arg.opts.Name = c.Name
+		if arg.opts.Name == "" {
+			arg.opts.Name = defaultName(i)
+		}
+		if names[arg.opts.Name] {
+			return resp, errors.New(errConfigInvalid)
+		}
+		names[arg.opts.Name] = true
+
+		if c.Workers < 0 || c.Queue < 0 || c.OutLimit < 0 {
+			return resp, errors.New(errConfigInvalid)
+		}
+		arg.opts.OutputLimit = c.OutLimit
+		arg.opts.OutputLog = c.OutLog
+		arg.opts.Workers = c.Workers
+		arg.opts.Queue = c.Queue
+		arg.opts.OrderKey = c.OrderKey
+		if arg.opts.Overflow, err = stream.ParseOverflow(c.Overflow); err != nil {
+			return resp, errors.New(errConfigInvalid)
+		}
+		if c.Retry != nil {
+			if arg.opts.Retry, err = c.Retry.policy(); err != nil {
+				return resp, errors.New(errConfigInvalid)
+			}
+		}
+		if c.HTTP != nil {
+			if arg.opts.HTTP, err = c.HTTP.options(); err != nil {
+				return resp, errors.New(errConfigInvalid)
+			}
+		}
+		if c.File != nil {
+			if arg.opts.File, err = c.File.options(); err != nil {
+				return resp, errors.New(errConfigInvalid)
+			}
+		}
+		if c.Redis != nil {
+			if arg.opts.Redis, err = c.Redis.options(); err != nil {
+				return resp, errors.New(errConfigInvalid)
+			}
+		}
+		if c.Syslog != nil {
+			if arg.opts.Syslog, err = c.Syslog.options(); err != nil {
+				return resp, errors.New(errConfigInvalid)
+			}
+		}
+		arg.opts.Parallel = c.Parallel
+		for _, ca := range c.Actions {
+			action, err := ca.options()
+			if err != nil {
+				return resp, errors.New(errConfigInvalid)
+			}
+			arg.opts.Actions = append(arg.opts.Actions, action)
+		}
+		for _, ca := range c.OnFailure {
+			action, err := ca.options()
+			if err != nil {
+				return resp, errors.New(errConfigInvalid)
+			}
+			arg.opts.OnFailure = append(arg.opts.OnFailure, action)
+		}
+
+		if err := validate(&arg); err != nil {
+			return resp, errors.New(errConfigInvalid)
+		}
 
 		resp = append(resp, arg)
 	}
@@ -153,71 +348,132 @@ func parseConfigFile(cFile []byte) ([]streamArgs, error) {
 
 // constructArgs validates the command line arguments and returns a valid
 // streamArgs for making a stream.
-func constructArgs(fp, dl, re, cmd, args string) (streamArgs, error) {
+func constructArgs(fp, dl, re, cmd, args string, shell bool) (streamArgs, error) {
+	a, err := newStreamArgs(fp, dl, re, cmd, args, shell)
+	if err != nil {
+		return a, err
+	}
+
+	if err := validate(&a); err != nil {
+		return a, err
+	}
+
+	return a, nil
+}
 
+// newStreamArgs returns the streamArgs for the arguments, splitting the
+// command's arguments, without validating them.
+func newStreamArgs(fp, dl, re, cmd, args string, shell bool) (streamArgs, error) {
 	a := streamArgs{
 		filepath:  fp,
 		delimiter: dl,
 		regexp:    re,
 		command:   cmd,
+		opts:      stream.Options{Shell: shell},
+	}
+
+	if strings.HasPrefix(fp, "exec:") {
+		words, err := splitArgs(strings.TrimPrefix(fp, "exec:"))
+		if err != nil {
+			return a, err
+		}
+		if len(words) == 0 {
+			return a, errors.New(errExec)
+		}
+		a.opts.Exec = &stream.ExecSource{Command: words[0], Args: words[1:]}
 	}
 
-	// Parse the provided arguments from left to right. The argument is either
-	// space separated strings or a quoted string with spaces preserved.
-	parser := func(r io.Reader) []string {
-		var buf bytes.Buffer
-		quote := '\''
-		space := ' '
-		eof := rune(0)
+	var err error
+	a.args, err = commandArgs(args, shell)
+	return a, err
+}
+
+// commandArgs returns the arguments for a command from the argument string.
+func commandArgs(args string, shell bool) ([]string, error) {
+	if shell {
+		// The shell does its own parsing of the arguments, so they're
+		// passed through as written.
 		ret := []string{}
-		argsRd := bufio.NewReader(r)
+		if args != "" {
+			ret = append(ret, args)
+		}
+		return ret, nil
+	}
+	return splitArgs(args)
+}
 
-		// Wraps any ReadRune() error with eof to stop parsing.
-		read := func(a *bufio.Reader) rune {
-			ch, _, err := argsRd.ReadRune()
-			if err != nil {
-				return eof
+// splitArgs splits an argument string into words the way a POSIX shell
+// would, without any expansions. Words are separated by unquoted spaces,
+// tabs or newlines. Single quotes preserve everything up to the closing
+// quote, double quotes preserve everything except backslash escapes of
+// $, `, ", \ and newline, and an unquoted backslash escapes the next
+// character.
+func splitArgs(args string) ([]string, error) {
+	var buf bytes.Buffer
+	ret := []string{}
+	inWord := false
+	rs := []rune(args)
+
+	for i := 0; i < len(rs); i++ {
+		ch := rs[i]
+		switch {
+		case ch == ' ' || ch == '\t' || ch == '\n':
+			if inWord {
+				ret = append(ret, buf.String())
+				buf.Reset()
+				inWord = false
 			}
-			return ch
-		}
-
-		for {
-			if ch := read(argsRd); ch == eof {
-				break
-			} else if ch == space {
-				continue
-			} else if ch == quote {
-				buf.WriteRune(ch)
-				for ch = read(argsRd); ch != quote && ch != eof; ch = read(argsRd) {
-					buf.WriteRune(ch)
-				}
-				buf.WriteRune(quote) // preserve the last quote
-			} else {
-				buf.WriteRune(ch)
-				for ch = read(argsRd); ch != space && ch != eof; ch = read(argsRd) {
-					buf.WriteRune(ch)
+		case ch == '\\':
+			i++
+			if i == len(rs) {
+				return ret, errors.New(errArgs)
+			}
+			// A backslash-newline is a line continuation.
+			if rs[i] != '\n' {
+				buf.WriteRune(rs[i])
+				inWord = true
+			}
+		case ch == '\'':
+			inWord = true
+			for i++; i < len(rs) && rs[i] != '\''; i++ {
+				buf.WriteRune(rs[i])
+			}
+			if i == len(rs) {
+				return ret, errors.New(errArgs)
+			}
+		case ch == '"':
+			inWord = true
+			for i++; i < len(rs) && rs[i] != '"'; i++ {
+				if rs[i] == '\\' && i+1 < len(rs) && strings.ContainsRune("$`\"\\\n", rs[i+1]) {
+					i++
+					if rs[i] == '\n' {
+						continue
+					}
 				}
+				buf.WriteRune(rs[i])
+			}
+			if i == len(rs) {
+				return ret, errors.New(errArgs)
 			}
-			ret = append(ret, buf.String())
-			buf.Reset()
+		default:
+			buf.WriteRune(ch)
+			inWord = true
 		}
-
-		return ret
 	}
-
-	a.args = parser(strings.NewReader(args))
-
-	if err := validate(&a); err != nil {
-		return a, err
+	if inWord {
+		ret = append(ret, buf.String())
 	}
 
-	return a, nil
+	return ret, nil
 }
 
 var (
 	errFilepath      = "a file must be provided or piped through stdin"
 	errRegexp        = "you must provide a valid regular expression"
 	errCommand       = "you must provide a command to run"
+	errArgs          = "the arguments contained an unterminated quote or escape"
+	errAction        = "a stream can only have one command or action"
+	errExec          = "an exec: source needs a command to run"
 	errConfig        = "the config file was empty or contained invalid json"
 	errConfigInvalid = "the config file contained invalid streammon config"
 )
@@ -245,16 +501,50 @@ func validate(a *streamArgs) error {
 	if err != nil {
 		return errors.New(errRegexp)
 	}
+	for _, pattern := range a.opts.Match {
+		if _, err := re.Compile(pattern); err != nil {
+			return errors.New(errRegexp)
+		}
+	}
+	if _, err := re.Compile(a.opts.Timestamp.Pattern); err != nil {
+		return errors.New(errRegexp)
+	}
 
-	// We're the same as 'tail', without a command.
-	if a.command == "" {
+	// We're the same as 'tail', without a command or action.
+	n := countActions(a)
+	if n == 0 && len(a.opts.Actions) == 0 {
 		return errors.New(errCommand)
 	}
+	if n > 1 {
+		return errors.New(errAction)
+	}
 
 	return nil
 
 }
 
+// countActions returns the number of commands and actions a stream has,
+// not counting its list of actions.
+func countActions(a *streamArgs) int {
+	n := 0
+	if a.command != "" {
+		n++
+	}
+	if a.opts.HTTP != nil {
+		n++
+	}
+	if a.opts.File != nil {
+		n++
+	}
+	if a.opts.Redis != nil {
+		n++
+	}
+	if a.opts.Syslog != nil {
+		n++
+	}
+	return n
+}
+
 // isStdin returns true when file has data piped from stdin.
 func isStdin() bool {
 	stat, _ := os.Stdin.Stat()
@@ -272,7 +562,7 @@ func isCfgFile(cfg string) bool {
 
 // getStreams constructs the streams based on configuration and returns the
 // stream.Stream array as required.
-func getStreams(cfg, filepath, delimiter, regexp, command, cargs string) ([]*stream.Stream, error) {
+func getStreams(cfg, filepath, delimiter, regexp, command, cargs string, shell bool) ([]*stream.Stream, error) {
 	var streams []*stream.Stream
 
 	// If there is a config file, ignore other flags and validate the config
@@ -296,6 +586,7 @@ func getStreams(cfg, filepath, delimiter, regexp, command, cargs string) ([]*str
 				str.delimiter,
 				str.filepath,
 				str.args,
+				str.opts,
 			)
 			streams = append(streams, s)
 			if err != nil {
@@ -303,10 +594,15 @@ func getStreams(cfg, filepath, delimiter, regexp, command, cargs string) ([]*str
 			}
 		}
 	} else {
-		strArgs, err := constructArgs(filepath, delimiter, regexp, command, cargs)
+		strArgs, err := constructArgs(filepath, delimiter, regexp, command, cargs, shell)
 		if err != nil {
 			exitErr(err.Error())
 		}
+		strArgs.opts.Name = defaultName(0)
+		strArgs.opts.MaxLine = maxLine
+		if strArgs.opts.LongLines, err = stream.ParseLongLines(longLines); err != nil {
+			return streams, err
+		}
 
 		s, err := stream.NewStream(
 			strArgs.regexp,
@@ -314,6 +610,7 @@ func getStreams(cfg, filepath, delimiter, regexp, command, cargs string) ([]*str
 			strArgs.delimiter,
 			strArgs.filepath,
 			strArgs.args,
+			strArgs.opts,
 		)
 		if err != nil {
 			return streams, err
@@ -327,13 +624,27 @@ func main() {
 	flag.Usage = func() {
 		exitErr(usage())
 	}
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		if err := replayCmd(os.Args[2:]); err != nil {
+			exitErr(err.Error())
+		}
+		os.Exit(0)
+	}
+	if len(os.Args) > 1 && os.Args[1] == "replay-dlq" {
+		if err := replayDLQ(os.Args[2:]); err != nil {
+			exitErr(err.Error())
+		}
+		os.Exit(0)
+	}
 	flag.Parse()
 
 	if len(os.Args) == 1 {
 		exitErr(usage())
 	}
 
-	streams, err := getStreams(config, filepath, delimiter, regexp, command, cargs)
+	stream.SetMaxJobs(jobs)
+	stream.SetPollInterval(pollEvery)
+	streams, err := getStreams(config, filepath, delimiter, regexp, command, cargs, shell)
 	if err != nil {
 		exitErr(err.Error())
 	}
@@ -342,6 +653,13 @@ func main() {
 		stream.LogDebug = true
 	}
 
+	if dlq != "" {
+		if deadLetters, err = stream.OpenDeadLetters(dlq); err != nil {
+			exitErr(err.Error())
+		}
+		defer deadLetters.Close()
+	}
+
 	var wg sync.WaitGroup
 	for _, s := range streams {
 		wg.Add(1)
@@ -349,7 +667,22 @@ func main() {
 	}
 
 	wg.Wait()
-	os.Exit(0)
+	fmt.Fprintln(os.Stdout, "No more files to watch, closing.")
+}
+
+// deadLetters is where failed commands are recorded, nil when there's no
+// dead-letter file.
+var deadLetters *stream.DeadLetters
+
+// deadLetter records a failed command in the dead-letter file, if there is
+// one.
+func deadLetter(rec stream.DeadLetter) {
+	if deadLetters == nil {
+		return
+	}
+	if err := deadLetters.Write(rec); err != nil {
+		fmt.Fprintf(os.Stderr, "error writing dead letter %s: \n", err.Error())
+	}
 }
 
 // watchStream sets up a watch on the Stream provided, and matches lines against
@@ -358,14 +691,19 @@ func watchStream(s *stream.Stream, wg *sync.WaitGroup) {
 	srw := stream.NewSubscriber(s)
 
 	// Listen for the lines received.
-	for line := range srw.Subscribe() {
-		match := s.Regexp.MatchString(line)
-		if match {
-			if err := s.ExecStreamComm(line); err != nil {
-				fmt.Fprintf(os.Stderr, "error exec command %s: \n", err.Error())
-			}
+	for ev := range srw.Subscribe() {
+		if s.Match(ev) {
+			ev, matched := ev, time.Now()
+			s.Dispatch(ev, func() {
+				if err := s.ExecEvent(ev); err != nil {
+					fmt.Fprintf(os.Stderr, "error exec command %s: \n", err.Error())
+					deadLetter(s.DeadLetter(ev, matched, err))
+				}
+			})
 		}
 	}
+	// Let any commands still queued finish before we're done.
+	s.Wait()
 	wg.Done()
 }
 
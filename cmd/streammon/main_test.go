@@ -6,6 +6,8 @@ import (
 	"os"
 	"sync"
 	"testing"
+
+	"github.com/fitzy101/streammon/internal/stream"
 )
 
 func TestValidate(t *testing.T) {
@@ -37,6 +39,26 @@ func TestValidate(t *testing.T) {
 				command:  "touch",
 			},
 		},
+		{
+			args: &streamArgs{
+				filepath: "/home",
+				regexp:   ".*",
+				opts: stream.Options{
+					File: &stream.FileOptions{Path: "/tmp/streammon.log"},
+				},
+			},
+		},
+		{
+			args: &streamArgs{
+				filepath: "/home",
+				regexp:   ".*",
+				command:  "touch",
+				opts: stream.Options{
+					HTTP: &stream.HTTPOptions{URL: "http://localhost"},
+				},
+			},
+			err: errors.New(errAction),
+		},
 	}
 
 	for _, table := range testTable {
@@ -104,6 +126,513 @@ func TestConfig(t *testing.T) {
 				}
 			]`),
 		},
+		{
+			config: []byte(`[
+				{
+					"filepath":"/var/log/messages",
+					"regexp":"MATCHTHIS.*",
+					"command":"grep -c foo | redis-cli",
+					"args":"publish key '#{1}' && true",
+					"shell":true
+				}
+			]`),
+		},
+		{
+			config: []byte(`[
+				{
+					"filepath":"/var/log/messages",
+					"regexp":"MATCHTHIS.*",
+					"command":"redis-cli",
+					"workers":4,
+					"queue":100,
+					"overflow":"drop-oldest",
+					"order_key":"#{2}"
+				}
+			]`),
+		},
+		{
+			config: []byte(`[
+				{
+					"filepath":"/var/log/messages",
+					"regexp":"MATCHTHIS.*",
+					"command":"redis-cli",
+					"overflow":"sometimes"
+				}
+			]`),
+			err: errors.New(errConfigInvalid),
+		},
+		{
+			config: []byte(`[
+				{
+					"filepath":"/var/log/messages",
+					"regexp":"MATCHTHIS.*",
+					"command":"redis-cli",
+					"retry":{
+						"attempts":5,
+						"backoff":"200ms",
+						"max_backoff":"10s",
+						"jitter":0.5,
+						"exit_codes":[1]
+					}
+				}
+			]`),
+		},
+		{
+			config: []byte(`[
+				{
+					"filepath":"/var/log/messages",
+					"regexp":"MATCHTHIS.*",
+					"command":"redis-cli",
+					"retry":{"attempts":5, "backoff":"soon"}
+				}
+			]`),
+			err: errors.New(errConfigInvalid),
+		},
+		{
+			config: []byte(`[
+				{
+					"filepath":"/var/log/messages",
+					"delimiter":" ",
+					"regexp":"ERROR.*",
+					"http":{
+						"method":"POST",
+						"url":"https://hooks.example.com/#{1}",
+						"headers":{"Content-Type":"application/json"},
+						"body":"{\"text\":\"#{0}\"}",
+						"timeout":"5s",
+						"success_codes":[200, 202],
+						"tls":{"insecure_skip_verify":true}
+					}
+				}
+			]`),
+		},
+		{
+			config: []byte(`[
+				{
+					"filepath":"/var/log/messages",
+					"regexp":"ERROR.*",
+					"http":{"method":"POST"}
+				}
+			]`),
+			err: errors.New(errConfigInvalid),
+		},
+		{
+			config: []byte(`[
+				{
+					"filepath":"/var/log/messages",
+					"delimiter":" ",
+					"regexp":"DHCPREQUEST",
+					"file":{
+						"path":"/tmp/streammon-%Y-%m-%d.log",
+						"format":"ip:#{8} mac:#{10}",
+						"max_size":10485760,
+						"max_backups":3,
+						"fsync":"1s"
+					}
+				}
+			]`),
+		},
+		{
+			config: []byte(`[
+				{
+					"filepath":"/var/log/messages",
+					"regexp":"DHCPREQUEST",
+					"command":"save-to-desktop.sh",
+					"file":{"path":"/tmp/streammon.log"}
+				}
+			]`),
+			err: errors.New(errConfigInvalid),
+		},
+		{
+			config: []byte(`[
+				{
+					"filepath":"/var/log/nginx/access.log",
+					"delimiter":" ",
+					"regexp":"GET.*",
+					"redis":{
+						"addr":"redis.internal:6379",
+						"password":"secret",
+						"db":2,
+						"command":"xadd",
+						"key":"requests",
+						"fields":{"ip":"#{1}", "date":"#{4}"},
+						"pool_size":8,
+						"timeout":"2s"
+					}
+				}
+			]`),
+		},
+		{
+			config: []byte(`[
+				{
+					"filepath":"/var/log/nginx/access.log",
+					"regexp":"GET.*",
+					"redis":{"command":"FLUSHALL", "key":"requests"}
+				}
+			]`),
+			err: errors.New(errConfigInvalid),
+		},
+		{
+			config: []byte(`[
+				{
+					"filepath":"/var/log/auth.log",
+					"delimiter":" ",
+					"regexp":"Failed password",
+					"syslog":{
+						"network":"tcp",
+						"addr":"logs.internal:601",
+						"facility":"authpriv",
+						"severity":"warning",
+						"tag":"sshguard",
+						"structured_data":{"user":"#{9}", "ip":"#{11}"},
+						"timeout":"2s"
+					}
+				}
+			]`),
+		},
+		{
+			config: []byte(`[
+				{
+					"filepath":"/var/log/auth.log",
+					"regexp":"Failed password",
+					"syslog":{"facility":"local9"}
+				}
+			]`),
+			err: errors.New(errConfigInvalid),
+		},
+		{
+			config: []byte(`[
+				{
+					"filepath":"/var/log/auth.log",
+					"delimiter":" ",
+					"regexp":"Failed password",
+					"actions":[
+						{"file":{"path":"/var/log/streammon/auth.log"}},
+						{"command":"notify-send", "args":"'failed login' #{9}"},
+						{"http":{"url":"https://alerts.internal/login?user=#{9}"}}
+					],
+					"parallel":true,
+					"on_failure":[
+						{"command":"logger", "args":["-t", "streammon", "alert failed for #{9}"]}
+					]
+				}
+			]`),
+		},
+		{
+			config: []byte(`[
+				{
+					"filepath":"/var/log/auth.log",
+					"regexp":"Failed password",
+					"command":"logger",
+					"on_failure":[{"command":"mail"}],
+					"actions":[{"command":"notify-send"}]
+				}
+			]`),
+		},
+		{
+			config: []byte(`[
+				{
+					"filepath":"/var/log/auth.log",
+					"regexp":"Failed password",
+					"actions":[{"command":"logger", "file":{"path":"/tmp/out"}}]
+				}
+			]`),
+			err: errors.New(errConfigInvalid),
+		},
+		{
+			config: []byte(`[
+				{
+					"filepath":"/var/log/auth.log",
+					"regexp":"Failed password",
+					"actions":[{"args":"-t streammon"}]
+				}
+			]`),
+			err: errors.New(errConfigInvalid),
+		},
+		{
+			config: []byte(`[
+				{
+					"filepath":"udp://0.0.0.0:514",
+					"delimiter":" ",
+					"regexp":"Failed password",
+					"command":"notify-send",
+					"args":"'#{severity} from #{hostname}' '#{app_name}: #{message}'"
+				}
+			]`),
+		},
+		{
+			config: []byte(`[
+				{
+					"filepath":"exec:journalctl -fu isc-dhcp-server",
+					"delimiter":" ",
+					"regexp":"DHCPREQUEST",
+					"command":"echo"
+				},
+				{
+					"exec":{
+						"command":"kubectl",
+						"args":["logs", "-f", "deploy/api"],
+						"stderr":true,
+						"backoff":"1s",
+						"max_backoff":"1m"
+					},
+					"regexp":"panic",
+					"command":"notify-send",
+					"args":"'#{pipe}' '#{0}'"
+				}
+			]`),
+		},
+		{
+			config: []byte(`[
+				{
+					"filepath":"/var/log/app/*.log",
+					"scan_interval":"1m",
+					"drain":"5s",
+					"regexp":"ERROR",
+					"command":"echo",
+					"args":"#{path}: #{0}"
+				}
+			]`),
+		},
+		{
+			config: []byte(`[
+				{
+					"filepath":"/var/log/app/*.log",
+					"scan_interval":"hourly",
+					"regexp":"ERROR",
+					"command":"echo"
+				}
+			]`),
+			err: errors.New(errConfigInvalid),
+		},
+		{
+			config: []byte(`[
+				{
+					"filepath":"/mnt/nfs/app.log",
+					"watch":"poll",
+					"regexp":"ERROR",
+					"command":"echo"
+				}
+			]`),
+		},
+		{
+			config: []byte(`[
+				{
+					"filepath":"exec:journalctl -f -o json",
+					"format":"journal",
+					"match":{"_SYSTEMD_UNIT":"^sshd\\.service$"},
+					"regexp":"Failed password",
+					"command":"echo",
+					"args":"#{_PID} #{MESSAGE}"
+				}
+			]`),
+		},
+		{
+			config: []byte(`[
+				{
+					"filepath":"/var/log/nginx/access.log",
+					"timestamp":{"layout":"nginx"},
+					"regexp":" 500 ",
+					"command":"echo",
+					"args":"#{ts} #{0}"
+				}
+			]`),
+		},
+		{
+			config: []byte(`[
+				{
+					"filepath":"/var/log/backup.log",
+					"timestamp":{"layout":"2006/01/02 15:04:05", "pattern":"^\\[(.+?)\\]", "location":"Europe/Berlin"},
+					"regexp":"failed",
+					"command":"echo"
+				}
+			]`),
+		},
+		{
+			config: []byte(`[
+				{
+					"filepath":"/var/log/backup.log",
+					"timestamp":{"pattern":"^\\[(.+?)\\]"},
+					"regexp":"failed",
+					"command":"echo"
+				}
+			]`),
+			err: errors.New(errConfigInvalid),
+		},
+		{
+			config: []byte(`[
+				{
+					"filepath":"/var/log/backup.log",
+					"timestamp":{"layout":"epoch", "pattern":"(\\d+"},
+					"regexp":"failed",
+					"command":"echo"
+				}
+			]`),
+			err: errors.New(errConfigInvalid),
+		},
+		{
+			config: []byte(`[
+				{
+					"filepath":"http://:8080/ingest/ci",
+					"ingest":{"token":"s3cret", "max_bytes":65536},
+					"regexp":"FAILED",
+					"command":"echo",
+					"args":"#{client}: #{0}"
+				}
+			]`),
+		},
+		{
+			config: []byte(`[
+				{
+					"filepath":"/var/log/ci.log",
+					"ingest":{"token":"s3cret"},
+					"regexp":"FAILED",
+					"command":"echo"
+				}
+			]`),
+			err: errors.New(errConfigInvalid),
+		},
+		{
+			config: []byte(`[
+				{
+					"filepath":"unixgram:///run/streammon/events.sock",
+					"regexp":"denied",
+					"command":"echo"
+				}
+			]`),
+		},
+		{
+			config: []byte(`[
+				{
+					"filepath":"C:/Logs/backup.log",
+					"encoding":"utf-16le",
+					"invalid":"replace",
+					"regexp":"failed",
+					"command":"echo"
+				}
+			]`),
+		},
+		{
+			config: []byte(`[
+				{
+					"filepath":"/var/log/appliance.log",
+					"encoding":"latin1",
+					"invalid":"ignore",
+					"regexp":"failed",
+					"command":"echo"
+				}
+			]`),
+			err: errors.New(errConfigInvalid),
+		},
+		{
+			config: []byte(`[
+				{
+					"filepath":"/var/log/pods/*/*/*.log",
+					"format":"cri",
+					"match":{"stream":"^stderr$"},
+					"regexp":"panic",
+					"command":"echo",
+					"args":"#{timestamp} #{path}: #{0}"
+				}
+			]`),
+		},
+		{
+			config: []byte(`[
+				{
+					"filepath":"exec:journalctl -f -o json",
+					"format":"journal",
+					"match":{"PRIORITY":"[0-3"},
+					"regexp":".*",
+					"command":"echo"
+				}
+			]`),
+			err: errors.New(errConfigInvalid),
+		},
+		{
+			config: []byte(`[
+				{
+					"filepath":"/var/log/app.log",
+					"format":"export",
+					"regexp":".*",
+					"command":"echo"
+				}
+			]`),
+			err: errors.New(errConfigInvalid),
+		},
+		{
+			config: []byte(`[
+				{
+					"filepath":"/var/log/nginx/access.log.*",
+					"once":true,
+					"regexp":" 500 ",
+					"command":"echo"
+				}
+			]`),
+		},
+		{
+			config: []byte(`[
+				{
+					"filepath":"/var/log/app.log",
+					"max_line":4096,
+					"long_lines":"split",
+					"regexp":"ERROR",
+					"command":"echo"
+				}
+			]`),
+		},
+		{
+			config: []byte(`[
+				{
+					"filepath":"/var/log/app.log",
+					"long_lines":"wrap",
+					"regexp":"ERROR",
+					"command":"echo"
+				}
+			]`),
+			err: errors.New(errConfigInvalid),
+		},
+		{
+			config: []byte(`[
+				{
+					"filepath":"/var/log/app.log",
+					"watch":"fanotify",
+					"regexp":"ERROR",
+					"command":"echo"
+				}
+			]`),
+			err: errors.New(errConfigInvalid),
+		},
+		{
+			config: []byte(`[
+				{
+					"filepath":"exec:",
+					"regexp":"DHCPREQUEST",
+					"command":"echo"
+				}
+			]`),
+			err: errors.New(errConfigInvalid),
+		},
+		{
+			config: []byte(`[
+				{
+					"filepath":"/var/log/messages",
+					"exec":{"command":"journalctl"},
+					"regexp":"DHCPREQUEST",
+					"command":"echo"
+				}
+			]`),
+			err: errors.New(errConfigInvalid),
+		},
+		{
+			config: []byte(`[
+				{
+					"filepath":"/var/log/auth.log",
+					"regexp":"Failed password",
+					"on_failure":[{"command":"logger"}]
+				}
+			]`),
+			err: errors.New(errConfigInvalid),
+		},
 	}
 
 	for _, table := range testTable {
@@ -133,6 +662,7 @@ func TestConstructArgs(t *testing.T) {
 		regexp    string
 		command   string
 		args      string
+		shell     bool
 		err       error
 		sArgs     *streamArgs
 	}{
@@ -184,7 +714,7 @@ func TestConstructArgs(t *testing.T) {
 				command:   "touch",
 				args: []string{
 					"foo",
-					"'bar baz'",
+					"bar baz",
 				},
 			},
 		},
@@ -201,7 +731,7 @@ func TestConstructArgs(t *testing.T) {
 				regexp:    ".*",
 				command:   "touch",
 				args: []string{
-					"'bar baz'",
+					"bar baz",
 					"foo",
 				},
 			},
@@ -219,7 +749,7 @@ func TestConstructArgs(t *testing.T) {
 				regexp:    ".*",
 				command:   "touch",
 				args: []string{
-					"'filename filename2'",
+					"filename filename2",
 				},
 			},
 		},
@@ -239,7 +769,7 @@ func TestConstructArgs(t *testing.T) {
 					"one",
 					"with",
 					"several",
-					"'filename filename2'",
+					"filename filename2",
 				},
 			},
 		},
@@ -257,7 +787,7 @@ func TestConstructArgs(t *testing.T) {
 				command:   "touch",
 				args: []string{
 					"foo",
-					"'bar baz'",
+					"bar baz",
 					"fuzz",
 				},
 			},
@@ -276,16 +806,35 @@ func TestConstructArgs(t *testing.T) {
 				command:   "touch",
 				args: []string{
 					"foo",
-					"'bar baz'",
+					"bar baz",
 					"fuzz",
-					"'groups are hard'",
+					"groups are hard",
 				},
 			},
 		},
+		{
+			filepath:  "/test",
+			delimiter: " ",
+			regexp:    ".*",
+			command:   "echo",
+			args:      "'bar baz' #{1} | wc -l",
+			shell:     true,
+			err:       nil,
+			sArgs: &streamArgs{
+				filepath:  "/test",
+				delimiter: " ",
+				regexp:    ".*",
+				command:   "echo",
+				args: []string{
+					"'bar baz' #{1} | wc -l",
+				},
+				opts: stream.Options{Shell: true},
+			},
+		},
 	}
 
 	for _, table := range testTable {
-		ret, retErr := constructArgs(table.filepath, table.delimiter, table.regexp, table.command, table.args)
+		ret, retErr := constructArgs(table.filepath, table.delimiter, table.regexp, table.command, table.args, table.shell)
 
 		if table.sArgs != nil {
 			if len(table.sArgs.args) != len(ret.args) {
@@ -315,6 +864,103 @@ func TestConstructArgs(t *testing.T) {
 	}
 }
 
+func TestSplitArgs(t *testing.T) {
+	testTable := []struct {
+		args string
+		exp  []string
+		err  error
+	}{
+		{
+			args: "",
+			exp:  []string{},
+		},
+		{
+			args: "  publish\terrors   #{0} ",
+			exp:  []string{"publish", "errors", "#{0}"},
+		},
+		{
+			args: "publish requests 'client ip #{1} date #{4}'",
+			exp:  []string{"publish", "requests", "client ip #{1} date #{4}"},
+		},
+		{
+			args: `"double \"quoted\" \$HOME \n" 'single \n'`,
+			exp:  []string{`double "quoted" $HOME \n`, `single \n`},
+		},
+		{
+			args: `escaped\ space '' "" mixed'quo'"tes"`,
+			exp:  []string{"escaped space", "", "", "mixedquotes"},
+		},
+		{
+			args: "'unterminated",
+			err:  errors.New(errArgs),
+		},
+		{
+			args: `"unterminated`,
+			err:  errors.New(errArgs),
+		},
+		{
+			args: `trailing\`,
+			err:  errors.New(errArgs),
+		},
+	}
+
+	for _, table := range testTable {
+		ret, retErr := splitArgs(table.args)
+		if retErr == nil && table.err != nil {
+			t.Errorf("No error returned, expected %v", table.err)
+			continue
+		}
+		if retErr != nil && table.err == nil {
+			t.Errorf("Error returned as %v, expected nil.", retErr)
+			continue
+		}
+		if table.err != nil {
+			if retErr.Error() != table.err.Error() {
+				t.Errorf("Error type was incorrect, got %v, want %v.", retErr.Error(), table.err)
+			}
+			continue
+		}
+		if len(ret) != len(table.exp) {
+			t.Errorf("Returned args were not the same as expected, got %q, want %q.", ret, table.exp)
+			continue
+		}
+		for idx := range table.exp {
+			if ret[idx] != table.exp[idx] {
+				t.Errorf("Returned args were not the same as expected, got %q, want %q.", ret[idx], table.exp[idx])
+			}
+		}
+	}
+}
+
+func TestConfigArgsArray(t *testing.T) {
+	config := []byte(`[
+		{
+			"filepath":"/var/log/messages",
+			"regexp":"MATCHTHIS.*",
+			"command":"redis-cli",
+			"args":["publish", "key", "it's #{1}"]
+		}
+	]`)
+	exp := []string{"publish", "key", "it's #{1}"}
+
+	ret, err := parseConfigFile(config)
+	if err != nil {
+		t.Fatalf("Error returned as %v, expected nil.", err)
+	}
+	if len(ret) != 1 || len(ret[0].args) != len(exp) {
+		t.Fatalf("Returned args were not the same as expected, got %v, want %v.", ret, exp)
+	}
+	for idx := range exp {
+		if ret[0].args[idx] != exp[idx] {
+			t.Errorf("Returned args were not the same as expected, got %q, want %q.", ret[0].args[idx], exp[idx])
+		}
+	}
+
+	if _, err := parseConfigFile([]byte(`[{"filepath":"/a","regexp":".*","command":"echo","args":3}]`)); err == nil {
+		t.Errorf("No error returned, expected %v", errConfig)
+	}
+}
+
 func TestGetStreams(t *testing.T) {
 	testTable := []struct {
 		config    string
@@ -349,7 +995,7 @@ func TestGetStreams(t *testing.T) {
 	}
 
 	for _, table := range testTable {
-		ret, retErr := getStreams(table.config, table.filepath, table.delimiter, table.regexp, table.command, table.args)
+		ret, retErr := getStreams(table.config, table.filepath, table.delimiter, table.regexp, table.command, table.args, false)
 		if retErr == nil && table.err != nil {
 			t.Errorf("No error returned, expected %v", table.err)
 			continue
@@ -398,7 +1044,7 @@ func TestWatchStream(t *testing.T) {
 		command:   "echo",
 		args:      "'#{0}'",
 	}
-	streams, err := getStreams(conf.config, conf.filepath, conf.delimiter, conf.regexp, conf.command, conf.args)
+	streams, err := getStreams(conf.config, conf.filepath, conf.delimiter, conf.regexp, conf.command, conf.args, false)
 
 	if err != nil {
 		t.Errorf("got error creating stream: %v", err)
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fitzy101/streammon/internal/stream"
+)
+
+const (
+	dreplay = "run the rules in a config file over the files they watch from the start, then exit."
+	dspeed  = "how much faster than they happened to replay the lines, eg. 10x, or max to not wait."
+	ddryRun = "print the matched lines instead of running their commands."
+)
+
+var (
+	errReplayConfig = "replay needs a config file"
+	errSpeed        = "the speed must be a positive number, eg. 10x, or max"
+	errReplaySource = "replay can only read files and stdin, not "
+)
+
+// sleep waits between replayed lines, replaced in tests.
+var sleep = time.Sleep
+
+// replayCmd implements the replay subcommand. Each stream in the config file
+// reads its files to the end without following them, waiting between lines
+// for the time between their events divided by the speed.
+func replayCmd(argv []string) error {
+	var speedArg string
+	var dryRun bool
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	fs.StringVar(&config, "config", "", dconfig)
+	fs.StringVar(&config, "k", "", dconfig)
+	fs.StringVar(&speedArg, "speed", "1x", dspeed)
+	fs.BoolVar(&dryRun, "dry-run", false, ddryRun)
+	fs.StringVar(&dlq, "dlq", "", ddlq)
+	fs.BoolVar(&log, "l", false, dlog)
+	if err := fs.Parse(argv); err != nil || fs.NArg() != 0 || !isCfgFile(config) {
+		return errors.New(errReplayConfig + "\n" + usage())
+	}
+	speed, err := parseSpeed(speedArg)
+	if err != nil {
+		return err
+	}
+	if log {
+		stream.LogDebug = true
+	}
+
+	streams, err := replayStreams(config)
+	if err != nil {
+		return err
+	}
+	if dlq != "" && !dryRun {
+		if deadLetters, err = stream.OpenDeadLetters(dlq); err != nil {
+			return err
+		}
+		defer deadLetters.Close()
+	}
+
+	lines, matched := runReplay(streams, &replayClock{speed: speed}, dryRun)
+	fmt.Fprintf(os.Stdout, "replayed %v lines, %v matched.\n", lines, matched)
+	return nil
+}
+
+// parseSpeed returns the speed of a replay from eg. 10x, zero for max.
+func parseSpeed(str string) (float64, error) {
+	if str == "max" {
+		return 0, nil
+	}
+	speed, err := strconv.ParseFloat(strings.TrimSuffix(str, "x"), 64)
+	if err != nil || speed <= 0 {
+		return 0, errors.New(errSpeed)
+	}
+	return speed, nil
+}
+
+// replayStreams makes the streams in the config file, reading their files
+// once.
+func replayStreams(cfg string) ([]*stream.Stream, error) {
+	contents, err := readFromFile(cfg)
+	if err != nil {
+		return nil, err
+	}
+	strs, err := parseConfigFile(contents)
+	if err != nil {
+		return nil, err
+	}
+
+	var streams []*stream.Stream
+	for _, str := range strs {
+		if !isFileSource(str.filepath) {
+			return nil, errors.New(errReplaySource + str.filepath)
+		}
+		str.opts.Once = true
+		s, err := stream.NewStream(str.regexp, str.command, str.delimiter, str.filepath, str.args, str.opts)
+		if err != nil {
+			return nil, err
+		}
+		streams = append(streams, s)
+	}
+	return streams, nil
+}
+
+// isFileSource returns whether a stream's filepath is a file, glob or
+// directory, or stdin, rather than a command or listener.
+func isFileSource(fp string) bool {
+	if strings.HasPrefix(fp, "exec:") {
+		return false
+	}
+	for _, scheme := range []string{"udp", "tcp", "unix", "unixgram", "http"} {
+		if strings.HasPrefix(fp, scheme+"://") {
+			return false
+		}
+	}
+	return true
+}
+
+// replayClock spaces out replayed events by the time between them, divided
+// by the speed. It's shared by the streams being replayed, so they're paced
+// from the same start, the time of the first event replayed. Each stream's
+// lines are still replayed in the order they were read, so a stream whose
+// events are earlier than the first isn't waited for until it catches up.
+type replayClock struct {
+	speed float64
+
+	lock  sync.Mutex
+	first time.Time
+	start time.Time
+}
+
+// wait waits until it's time to replay an event that occurred at t.
+func (c *replayClock) wait(t time.Time) {
+	if c.speed == 0 {
+		return
+	}
+	c.lock.Lock()
+	if c.first.IsZero() {
+		c.first, c.start = t, time.Now()
+	}
+	at := c.start.Add(time.Duration(float64(t.Sub(c.first)) / c.speed))
+	c.lock.Unlock()
+
+	if d := time.Until(at); d > 0 {
+		sleep(d)
+	}
+}
+
+// runReplay replays the streams until they've read all of their lines,
+// returning the number of lines read and matched.
+func runReplay(streams []*stream.Stream, clock *replayClock, dryRun bool) (int64, int64) {
+	var lines, matched int64
+	var wg sync.WaitGroup
+	for _, s := range streams {
+		wg.Add(1)
+		go func(s *stream.Stream) {
+			defer wg.Done()
+			for ev := range stream.NewSubscriber(s).Subscribe() {
+				atomic.AddInt64(&lines, 1)
+				// A line without a timestamp of its own, such as
+				// a stack trace's, is replayed right after the
+				// one before it.
+				if ev.Stamped {
+					clock.wait(ev.Time)
+				}
+				if !s.Match(ev) {
+					continue
+				}
+				atomic.AddInt64(&matched, 1)
+				if dryRun {
+					fmt.Fprintf(os.Stdout, "%s %s matched: %s\n", ev.Time.Format(time.RFC3339Nano), s.Name(), ev.Line)
+					continue
+				}
+				ev, at := ev, time.Now()
+				s.Dispatch(ev, func() {
+					if err := s.ExecEvent(ev); err != nil {
+						fmt.Fprintf(os.Stderr, "error exec command %s: \n", err.Error())
+						deadLetter(s.DeadLetter(ev, at, err))
+					}
+				})
+			}
+			s.Wait()
+		}(s)
+	}
+	wg.Wait()
+	return lines, matched
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestParseSpeed(t *testing.T) {
+	testTable := []struct {
+		str   string
+		speed float64
+		err   bool
+	}{
+		{str: "1x", speed: 1},
+		{str: "10x", speed: 10},
+		{str: "0.5", speed: 0.5},
+		{str: "max", speed: 0},
+		{str: "0x", err: true},
+		{str: "-2x", err: true},
+		{str: "fast", err: true},
+	}
+
+	for _, test := range testTable {
+		speed, err := parseSpeed(test.str)
+		if (err != nil) != test.err {
+			t.Errorf("error for %q was incorrect, expected %v, got %v", test.str, test.err, err)
+		}
+		if speed != test.speed {
+			t.Errorf("speed for %q was incorrect, expected %v, got %v", test.str, test.speed, speed)
+		}
+	}
+}
+
+func TestReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "streammon")
+	if err != nil {
+		t.Fatalf("got error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	defer func() {
+		config, dlq = "", ""
+		sleep = time.Sleep
+	}()
+
+	// The lines are a minute apart, which is six seconds at 10x. The line
+	// without a timestamp isn't waited for.
+	logPath := path.Join(dir, "app.log")
+	ioutil.WriteFile(logPath, []byte(
+		"2023-10-11T22:00:00Z ok\n"+
+			"2023-10-11T22:01:00Z ERROR disk full\n"+
+			"\tat disk.go:12\n"+
+			"2023-10-11T22:02:00Z ERROR disk full\n"), 0644)
+	out := path.Join(dir, "out")
+	cfg := path.Join(dir, "streammon.conf")
+	ioutil.WriteFile(cfg, []byte(`[
+		{
+			"filepath":"`+logPath+`",
+			"timestamp":{"layout":"rfc3339"},
+			"regexp":"ERROR",
+			"command":"sh",
+			"args":["-c", "echo #{ts} >> `+out+`"]
+		}
+	]`), 0644)
+
+	testTable := []struct {
+		args []string
+		out  string
+		wait time.Duration
+	}{
+		{args: []string{"-k", cfg, "--speed", "10x", "--dry-run"}, wait: 12 * time.Second},
+		{args: []string{"-k", cfg, "--speed", "max"}, out: "2023-10-11T22:01:00Z\n2023-10-11T22:02:00Z\n"},
+	}
+
+	for _, test := range testTable {
+		// The sleeps don't pass any time, so the longest is how long
+		// after the first line the last is replayed.
+		var waited time.Duration
+		sleep = func(d time.Duration) {
+			if d > waited {
+				waited = d
+			}
+		}
+		os.Remove(out)
+		if err := replayCmd(test.args); err != nil {
+			t.Fatalf("got error replaying %v: %v", test.args, err)
+		}
+
+		b, _ := ioutil.ReadFile(out)
+		if string(b) != test.out {
+			t.Errorf("output of %v was incorrect, expected %q, got %q", test.args, test.out, string(b))
+		}
+		// Allow for the time it took to replay the lines.
+		if waited > test.wait || waited < test.wait-time.Second {
+			t.Errorf("wait of %v was incorrect, expected %v, got %v", test.args, test.wait, waited)
+		}
+	}
+
+	if err := replayCmd([]string{"-k", cfg, "--speed", "fast"}); err == nil {
+		t.Errorf("expected an error for an invalid speed")
+	}
+	ioutil.WriteFile(cfg, []byte(`[{"filepath":"udp://:514","regexp":".*","command":"echo"}]`), 0644)
+	if err := replayCmd([]string{"-k", cfg}); err == nil {
+		t.Errorf("expected an error for a source that isn't a file")
+	}
+}
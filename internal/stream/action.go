@@ -0,0 +1,215 @@
+package stream
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Action is something a Stream does with a line that matched its regexp,
+// such as running a command or making an HTTP request.
+type Action interface {
+	Run(ev Event) error
+}
+
+// ActionOptions configures one of a Stream's actions. Exactly one of the
+// command or the built-in actions is set.
+type ActionOptions struct {
+	// Command is run with Args, through ShellPath when Shell is true, the
+	// same as a Stream's own command.
+	Command string
+	Args    []string
+	Shell   bool
+
+	HTTP   *HTTPOptions
+	File   *FileOptions
+	Redis  *RedisOptions
+	Syslog *SyslogOptions
+}
+
+// newAction returns the Action configured by opts.
+func (s *Stream) newAction(opts ActionOptions) (Action, error) {
+	switch {
+	case opts.HTTP != nil:
+		return newHTTPAction(*opts.HTTP, s.delim, s.outputLimit)
+	case opts.File != nil:
+		return newFileAction(*opts.File, s.delim)
+	case opts.Redis != nil:
+		return newRedisAction(*opts.Redis, s.delim)
+	case opts.Syslog != nil:
+		return newSyslogAction(*opts.Syslog, s.delim)
+	case opts.Command != "":
+		return s.newCommandAction(opts.Command, opts.Args, opts.Shell), nil
+	}
+	return nil, errors.New("an action needs a command, http, file, redis or syslog")
+}
+
+// actionID returns what an action configured by opts does and to what, such
+// as "http POST https://example.com/hook", to tell it apart from a Stream's
+// other actions when a dead letter is replayed.
+func actionID(opts ActionOptions) string {
+	switch {
+	case opts.HTTP != nil:
+		method := strings.ToUpper(opts.HTTP.Method)
+		if method == "" {
+			method = http.MethodPost
+		}
+		return "http " + method + " " + opts.HTTP.URL
+	case opts.File != nil:
+		return "file " + opts.File.Path
+	case opts.Redis != nil:
+		return strings.TrimSpace("redis " + opts.Redis.Addr + " " + strings.ToUpper(opts.Redis.Command) + " " + opts.Redis.Key)
+	case opts.Syslog != nil:
+		return strings.TrimSpace("syslog " + opts.Syslog.Network + " " + opts.Syslog.Addr)
+	}
+	return strings.Join(append([]string{"command", opts.Command}, opts.Args...), " ")
+}
+
+// newActions returns the Actions configured by each of opts.
+func (s *Stream) newActions(opts []ActionOptions) ([]Action, error) {
+	actions := []Action{}
+	for _, o := range opts {
+		a, err := s.newAction(o)
+		if err != nil {
+			return nil, err
+		}
+		actions = append(actions, a)
+	}
+	return actions, nil
+}
+
+// commandAction runs a command for a matched line, with a Stream that holds
+// the command in place of the rule's own.
+type commandAction struct {
+	s *Stream
+}
+
+// newCommandAction returns a commandAction for cmd that shares the Stream's
+// delimiter, name and output settings.
+func (s *Stream) newCommandAction(cmd string, args []string, shell bool) commandAction {
+	return commandAction{s: &Stream{
+		cmd:         cmd,
+		args:        args,
+		fields:      parseFields(args),
+		delim:       s.delim,
+		shell:       shell,
+		name:        s.name,
+		outputLimit: s.outputLimit,
+		outputLog:   s.outputLog,
+	}}
+}
+
+// Run executes the command once for a matched line.
+func (a commandAction) Run(ev Event) error {
+	return a.s.execCommand(ev)
+}
+
+// runAction runs a for a matched line under the Stream's Retry policy.
+func (s *Stream) runAction(a Action, ev Event) error {
+	return s.retry.Do(func() error {
+		return a.Run(ev)
+	})
+}
+
+// ActionError is returned when a Stream's actions for a matched line didn't
+// all succeed. Pending holds the indexes of the actions that didn't: the one
+// that failed and those after it when they run in sequence, or each one that
+// failed when they run in parallel.
+type ActionError struct {
+	Pending []int
+	Err     error
+}
+
+// Error returns the error of the first action in order that failed.
+func (e *ActionError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the error of the first action in order that failed.
+func (e *ActionError) Unwrap() error {
+	return e.Err
+}
+
+// runSequence runs the Stream's actions with the indexes in run for a
+// matched line one after the other, stopping at the first that fails.
+func (s *Stream) runSequence(ev Event, run []int) error {
+	for n, i := range run {
+		if err := s.runAction(s.actions[i], ev); err != nil {
+			return &ActionError{Pending: run[n:], Err: err}
+		}
+	}
+	return nil
+}
+
+// runParallel runs the Stream's actions with the indexes in run for a
+// matched line at once.
+func (s *Stream) runParallel(ev Event, run []int) error {
+	errs := make([]error, len(run))
+	var wg sync.WaitGroup
+	for n, i := range run {
+		wg.Add(1)
+		go func(n int, a Action) {
+			defer wg.Done()
+			errs[n] = s.runAction(a, ev)
+		}(n, s.actions[i])
+	}
+	wg.Wait()
+
+	var actErr *ActionError
+	for n, err := range errs {
+		if err == nil {
+			continue
+		}
+		if actErr == nil {
+			actErr = &ActionError{Err: err}
+		}
+		actErr.Pending = append(actErr.Pending, run[n])
+	}
+	if actErr != nil {
+		return actErr
+	}
+	return nil
+}
+
+// template is text containing field tokens that's filled in from a matched
+// line, such as the URL or body of an HTTP action.
+type template struct {
+	text   string
+	delim  string
+	escape func(string) string
+}
+
+// newTemplate returns a template for text. The field values are passed
+// through escape, if it's not nil, before they're inserted.
+func newTemplate(text, delim string, escape func(string) string) template {
+	return template{
+		text:   text,
+		delim:  delim,
+		escape: escape,
+	}
+}
+
+// render returns the template's text with the field tokens replaced by the
+// fields of the matched line and its named fields.
+func (t template) render(ev Event) string {
+	return renderTokens(t.text, lineFields(ev.Line, t.delim), ev.names(), t.escape)
+}
+
+// urlEscape escapes a field for any part of a URL.
+func urlEscape(str string) string {
+	return strings.Replace(url.QueryEscape(str), "+", "%20", -1)
+}
+
+// jsonEscape escapes a field for use inside a JSON string.
+func jsonEscape(str string) string {
+	var sbuff strings.Builder
+	enc := json.NewEncoder(&sbuff)
+	enc.SetEscapeHTML(false)
+	enc.Encode(str)
+	// Trim the quotes and the newline added by Encode.
+	quoted := sbuff.String()
+	return quoted[1 : len(quoted)-2]
+}
@@ -0,0 +1,141 @@
+package stream
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExecStreamCommActions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "streammon")
+	if err != nil {
+		t.Fatalf("got error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	testTable := []struct {
+		name       string
+		actions    []string
+		parallel   bool
+		resume     []string
+		exp        string
+		expFailed  bool
+		expPending []int
+	}{
+		{
+			name:    "sequence",
+			actions: []string{"echo first #{1}", "echo second #{1}"},
+			exp:     "first a\nsecond a\n",
+		},
+		{
+			name:       "stop",
+			actions:    []string{"echo first #{1}", "exit 3", "echo second #{1}"},
+			exp:        "first a\nfailed a\n",
+			expFailed:  true,
+			expPending: []int{1, 2},
+		},
+		{
+			name:       "parallel",
+			actions:    []string{"exit 3", "echo second #{1}"},
+			parallel:   true,
+			exp:        "second a\nfailed a\n",
+			expFailed:  true,
+			expPending: []int{0},
+		},
+		{
+			name:    "resume",
+			actions: []string{"echo first #{1}", "echo second #{1}", "echo third #{1}"},
+			resume:  []string{"echo second #{1}", "echo third #{1}"},
+			exp:     "second a\nthird a\n",
+		},
+		{
+			name:    "resume-reordered",
+			actions: []string{"echo third #{1}", "echo first #{1}", "echo second #{1}"},
+			resume:  []string{"echo second #{1}", "echo third #{1}"},
+			exp:     "third a\nsecond a\n",
+		},
+		{
+			name:    "resume-changed",
+			actions: []string{"echo first #{1}"},
+			resume:  []string{"echo second #{1}", "echo third #{1}"},
+			exp:     "first a\n",
+		},
+	}
+
+	for _, test := range testTable {
+		out := filepath.Join(dir, test.name)
+		opts := Options{Parallel: test.parallel}
+		for _, cmd := range test.actions {
+			opts.Actions = append(opts.Actions, ActionOptions{
+				Command: cmd + " >> " + out,
+				Shell:   true,
+			})
+		}
+		opts.OnFailure = []ActionOptions{{
+			File: &FileOptions{Path: out, Format: "failed #{1}"},
+		}}
+
+		s, err := NewStream(".*", "", " ", "", []string{}, opts)
+		if err != nil {
+			t.Fatalf("got error creating stream: %v", err)
+		}
+
+		resume := []string{}
+		for _, cmd := range test.resume {
+			resume = append(resume, actionID(ActionOptions{Command: cmd + " >> " + out, Shell: true}))
+		}
+		err = s.ExecActions(Event{Line: "a b"}, resume)
+		var cmdErr *CommandError
+		if test.expFailed && (!errors.As(err, &cmdErr) || cmdErr.ExitCode != 3) {
+			t.Errorf("%s: expected a CommandError with exit code 3, got %v", test.name, err)
+		}
+		var actErr *ActionError
+		if test.expFailed && (!errors.As(err, &actErr) || fmt.Sprint(actErr.Pending) != fmt.Sprint(test.expPending)) {
+			t.Errorf("%s: expected pending actions %v, got %v", test.name, test.expPending, err)
+		}
+		if !test.expFailed && err != nil {
+			t.Errorf("%s: got error executing actions: %v", test.name, err)
+		}
+
+		resp, err := ioutil.ReadFile(out)
+		if err != nil {
+			t.Fatalf("got error reading output: %v", err)
+		}
+		if string(resp) != test.exp {
+			t.Errorf("%s: output was incorrect, expected %q, got %q", test.name, test.exp, string(resp))
+		}
+	}
+}
+
+func TestNewActionEmpty(t *testing.T) {
+	_, err := NewStream(".*", "", " ", "", []string{}, Options{
+		OnFailure: []ActionOptions{{Args: []string{"-c"}}},
+	})
+	if err == nil {
+		t.Errorf("expected an error for an action with nothing to do")
+	}
+}
+
+func TestActionID(t *testing.T) {
+	testTable := []struct {
+		opts ActionOptions
+		exp  string
+	}{
+		{opts: ActionOptions{Command: "notify", Args: []string{"#{1}", "-v"}}, exp: "command notify #{1} -v"},
+		{opts: ActionOptions{HTTP: &HTTPOptions{URL: "https://example.com/hook"}}, exp: "http POST https://example.com/hook"},
+		{opts: ActionOptions{HTTP: &HTTPOptions{Method: "put", URL: "https://example.com/#{1}"}}, exp: "http PUT https://example.com/#{1}"},
+		{opts: ActionOptions{File: &FileOptions{Path: "/var/log/matched-%Y.log"}}, exp: "file /var/log/matched-%Y.log"},
+		{opts: ActionOptions{Redis: &RedisOptions{Addr: "localhost:6379", Command: "lpush", Key: "events"}}, exp: "redis localhost:6379 LPUSH events"},
+		{opts: ActionOptions{Syslog: &SyslogOptions{}}, exp: "syslog"},
+		{opts: ActionOptions{Syslog: &SyslogOptions{Network: "tcp", Addr: "logs.internal:601"}}, exp: "syslog tcp logs.internal:601"},
+	}
+
+	for _, test := range testTable {
+		if id := actionID(test.opts); id != test.exp {
+			t.Errorf("action id was incorrect, expected %q, got %q", test.exp, id)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package stream
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// magics are the first bytes of each compressed format that's read.
+var magics = []struct {
+	format string
+	magic  []byte
+}{
+	{format: "gzip", magic: []byte{0x1f, 0x8b}},
+	{format: "zstd", magic: []byte{0x28, 0xb5, 0x2f, 0xfd}},
+	{format: "bzip2", magic: []byte("BZh")},
+}
+
+// compression returns the format the file at path is compressed with, by
+// its magic bytes, or an empty string when it isn't.
+func compression(path string) string {
+	file, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+	format, _ := sniff(bufio.NewReader(file))
+	return format
+}
+
+// sniff returns the compressed format of rd, or an empty string when it
+// isn't compressed.
+func sniff(rd *bufio.Reader) (string, error) {
+	head, err := rd.Peek(4)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	for _, m := range magics {
+		if bytes.HasPrefix(head, m.magic) {
+			return m.format, nil
+		}
+	}
+	return "", nil
+}
+
+// decompress returns a reader of the decompressed contents of rd, which is
+// read as it is when it isn't compressed. The returned func releases the
+// decompressor.
+func decompress(rd io.Reader) (io.Reader, func(), error) {
+	br := bufio.NewReader(rd)
+	format, err := sniff(br)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch format {
+	case "gzip":
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, nil, err
+		}
+		return zr, func() { zr.Close() }, nil
+	case "zstd":
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, nil, err
+		}
+		return zr, zr.Close, nil
+	case "bzip2":
+		return bzip2.NewReader(br), func() {}, nil
+	}
+	return br, func() {}, nil
+}
+
+// readFile publishes the lines of the file at path, decompressing it if it's
+// compressed, with the path as a named field. It reads the file to the end
+// once, without following it.
+func (s *Stream) readFile(path string, swr Publisher) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	rd, release, err := decompress(file)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return s.scanLines(rd, path, map[string]string{"path": path}, swr)
+}
+
+// readOnce reads each of the files at paths to the end in turn, then closes
+// swr.
+func (s *Stream) readOnce(paths []string, swr Publisher) {
+	for _, path := range paths {
+		if err := s.readFile(path, swr); err != nil {
+			fmt.Fprintf(os.Stderr, "error reading %s: %s\n", path, err)
+		}
+	}
+	swr.Close()
+}
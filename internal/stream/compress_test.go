@@ -0,0 +1,113 @@
+package stream
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// bzip2Lines is "one\ntwo\n" compressed with bzip2, which the standard
+// library can only decompress.
+const bzip2Lines = "425a6839314159265359a7142b77000002c180001002018480200021800c0238f51b8bb9229c2848538a15bb80"
+
+func TestReadCompressed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "streammon")
+	if err != nil {
+		t.Fatalf("got error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte("one\ntwo\n"))
+	zw.Close()
+	enc, _ := zstd.NewWriter(nil)
+	zst := enc.EncodeAll([]byte("one\ntwo\n"), nil)
+	bz, _ := hex.DecodeString(bzip2Lines)
+
+	testTable := []struct {
+		name   string
+		data   []byte
+		format string
+	}{
+		{name: "access.log.1", data: []byte("one\ntwo"), format: ""},
+		{name: "access.log.2.gz", data: gz.Bytes(), format: "gzip"},
+		{name: "access.log.3.zst", data: zst, format: "zstd"},
+		{name: "access.log.4.bz2", data: bz, format: "bzip2"},
+	}
+
+	for _, test := range testTable {
+		path := filepath.Join(dir, test.name)
+		if err := ioutil.WriteFile(path, test.data, 0644); err != nil {
+			t.Fatalf("got error writing %v: %v", path, err)
+		}
+		if format := compression(path); format != test.format {
+			t.Errorf("format of %v was incorrect, expected %q, got %q", test.name, test.format, format)
+		}
+
+		// Plain files are only read to the end when the Stream is set
+		// to read once.
+		s, err := NewStream(".*", "echo", " ", path, []string{}, Options{Once: true})
+		if err != nil {
+			t.Fatalf("got error creating stream: %v", err)
+		}
+		sub := NewSubscriber(s).Subscribe()
+		var lines []string
+		timeout := time.After(time.Second)
+	read:
+		for {
+			select {
+			case ev, ok := <-sub:
+				if !ok {
+					break read
+				}
+				if ev.Fields["path"] != path {
+					t.Errorf("path of %v was incorrect, got %q", test.name, ev.Fields["path"])
+				}
+				lines = append(lines, ev.Line)
+			case <-timeout:
+				t.Fatalf("timed out reading %v", test.name)
+			}
+		}
+		if len(lines) != 2 || lines[0] != "one" || lines[1] != "two" {
+			t.Errorf("lines of %v were incorrect, expected [one two], got %v", test.name, lines)
+		}
+	}
+}
+
+func TestScanGlobCompressed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "streammon")
+	if err != nil {
+		t.Fatalf("got error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte("archived\n"))
+	zw.Close()
+	ioutil.WriteFile(filepath.Join(dir, "app.log.1.gz"), gz.Bytes(), 0644)
+
+	s, err := NewStream(".*", "echo", " ", dir, []string{}, Options{})
+	if err != nil {
+		t.Fatalf("got error creating stream: %v", err)
+	}
+	tails := newGlobTails()
+	defer tails.stop()
+	s.scanGlob(filepath.Join(dir, "*"), tails, NewPublisher(s))
+	select {
+	case ev := <-s.lines:
+		if ev.Line != "archived" {
+			t.Errorf("expected %q, got %+v", "archived", ev)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for the compressed file")
+	}
+}
@@ -0,0 +1,158 @@
+package stream
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// DeadLetter is an execution of a Stream's command that ultimately failed,
+// as written to the dead-letter file.
+type DeadLetter struct {
+	Stream   string            `json:"stream"`
+	Rule     string            `json:"rule"`
+	Line     string            `json:"line"`
+	Fields   map[string]string `json:"fields,omitempty"`
+	Time     time.Time         `json:"time"`
+	Command  string            `json:"command"`
+	Args     []string          `json:"args"`
+	ExitCode int               `json:"exit_code"`
+	Error    string            `json:"error"`
+	Stderr   string            `json:"stderr,omitempty"`
+	Matched  time.Time         `json:"matched"`
+	Failed   time.Time         `json:"failed"`
+	// Actions identify the rule's actions that didn't succeed, when some
+	// of them did, by what they do and to what. Only these are run when
+	// it's replayed, if the rule still has them all.
+	Actions []string `json:"actions,omitempty"`
+}
+
+// DeadLetter returns the record of a matched line whose command failed
+// with err.
+func (s *Stream) DeadLetter(ev Event, matched time.Time, err error) DeadLetter {
+	d := DeadLetter{
+		Stream:   s.source(),
+		Rule:     s.name,
+		Line:     ev.Line,
+		Fields:   ev.Fields,
+		Time:     ev.Time,
+		ExitCode: -1,
+		Error:    err.Error(),
+		Matched:  matched,
+		Failed:   time.Now(),
+	}
+
+	var cmdErr *CommandError
+	if errors.As(err, &cmdErr) {
+		d.Command = cmdErr.Command
+		d.Args = cmdErr.Args
+		d.ExitCode = cmdErr.ExitCode
+		d.Stderr = cmdErr.Stderr
+		d.Error = cmdErr.Err.Error()
+	}
+	var actErr *ActionError
+	if errors.As(err, &actErr) && len(actErr.Pending) < len(s.actions) {
+		for _, i := range actErr.Pending {
+			d.Actions = append(d.Actions, s.actionIDs[i])
+		}
+	}
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		d.Command = httpErr.Method
+		d.Args = []string{httpErr.URL}
+	}
+	return d
+}
+
+// DeadLetters appends DeadLetter records to a JSON lines file.
+type DeadLetters struct {
+	lock sync.Mutex
+	file *os.File
+}
+
+// OpenDeadLetters opens the dead-letter file at path for appending, creating
+// it if it doesn't exist.
+func OpenDeadLetters(path string) (*DeadLetters, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &DeadLetters{file: file}, nil
+}
+
+// Write appends a record to the file as a single line.
+func (d *DeadLetters) Write(rec DeadLetter) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	_, err = d.file.Write(append(b, '\n'))
+	return err
+}
+
+// Close closes the dead-letter file.
+func (d *DeadLetters) Close() error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.file.Close()
+}
+
+// ReadDeadLetters returns the records in the dead-letter file at path.
+func ReadDeadLetters(path string) ([]DeadLetter, error) {
+	recs := []DeadLetter{}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return recs, err
+	}
+	defer file.Close()
+
+	rd := bufio.NewReader(file)
+	for n := 1; ; n++ {
+		b, err := rd.ReadBytes('\n')
+		if len(bytes.TrimSpace(b)) > 0 {
+			var rec DeadLetter
+			if jerr := json.Unmarshal(b, &rec); jerr != nil {
+				return recs, fmt.Errorf("line %v of %s: %s", n, path, jerr)
+			}
+			recs = append(recs, rec)
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return recs, err
+		}
+	}
+	return recs, nil
+}
+
+// WriteDeadLetters replaces the dead-letter file at path with recs.
+func WriteDeadLetters(path string, recs []DeadLetter) error {
+	tmp := path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	d := DeadLetters{file: file}
+	for _, rec := range recs {
+		if err := d.Write(rec); err != nil {
+			d.Close()
+			os.Remove(tmp)
+			return err
+		}
+	}
+	if err := d.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
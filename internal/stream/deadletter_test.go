@@ -0,0 +1,121 @@
+package stream
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCommandError(t *testing.T) {
+	s, err := NewStream(
+		".*",
+		"sh",
+		" ",
+		"/var/log/messages",
+		[]string{"-c", "echo no route to #{2} >&2; exit 3"},
+		Options{Name: "redis"},
+	)
+	if err != nil {
+		t.Fatalf("got error creating stream: %v", err)
+	}
+
+	line := "publish host1"
+	matched := time.Now()
+	err = s.ExecStreamComm(line)
+	var cmdErr *CommandError
+	if !errors.As(err, &cmdErr) {
+		t.Fatalf("expected a CommandError, got %v", err)
+	}
+
+	rec := s.DeadLetter(Event{Line: line}, matched, err)
+	exp := DeadLetter{
+		Stream:   "/var/log/messages",
+		Rule:     "redis",
+		Line:     line,
+		Command:  "sh",
+		ExitCode: 3,
+		Error:    "exit status 3",
+		Stderr:   "no route to host1",
+		Matched:  matched,
+	}
+	if rec.Stream != exp.Stream || rec.Rule != exp.Rule || rec.Line != exp.Line ||
+		rec.Command != exp.Command || rec.ExitCode != exp.ExitCode ||
+		rec.Error != exp.Error || rec.Stderr != exp.Stderr || !rec.Matched.Equal(exp.Matched) {
+		t.Errorf("dead letter was incorrect, expected %+v, got %+v", exp, rec)
+	}
+	if len(rec.Args) != 2 || rec.Args[1] != "echo no route to host1 >&2; exit 3" {
+		t.Errorf("dead letter args were incorrect, got %v", rec.Args)
+	}
+	if rec.Failed.Before(rec.Matched) {
+		t.Errorf("dead letter failed before it matched: %+v", rec)
+	}
+	if rec.Actions != nil {
+		t.Errorf("expected no actions to resume from when none succeeded, got %v", rec.Actions)
+	}
+
+	s, err = NewStream(".*", "", " ", "", []string{}, Options{
+		Actions: []ActionOptions{{Command: "true"}, {Command: "exit 3", Shell: true}},
+	})
+	if err != nil {
+		t.Fatalf("got error creating stream: %v", err)
+	}
+	err = s.ExecStreamComm(line)
+	rec = s.DeadLetter(Event{Line: line}, matched, err)
+	if len(rec.Actions) != 1 || rec.Actions[0] != "command exit 3" || rec.ExitCode != 3 {
+		t.Errorf("expected to resume from the failed action, got %+v", rec)
+	}
+}
+
+func TestDeadLetters(t *testing.T) {
+	dir, err := ioutil.TempDir("", "streammon")
+	if err != nil {
+		t.Fatalf("got error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "dlq.jsonl")
+
+	recs := []DeadLetter{
+		{Rule: "one", Line: "first line", ExitCode: 1},
+		{Rule: "two", Line: "second\nline", ExitCode: -1},
+	}
+	for _, rec := range recs {
+		d, err := OpenDeadLetters(path)
+		if err != nil {
+			t.Fatalf("got error opening dead letters: %v", err)
+		}
+		if err := d.Write(rec); err != nil {
+			t.Errorf("got error writing dead letter: %v", err)
+		}
+		d.Close()
+	}
+
+	b, _ := ioutil.ReadFile(path)
+	if n := strings.Count(string(b), "\n"); n != 2 {
+		t.Errorf("expected 2 lines in the dead-letter file, got %v", n)
+	}
+
+	got, err := ReadDeadLetters(path)
+	if err != nil {
+		t.Fatalf("got error reading dead letters: %v", err)
+	}
+	if len(got) != len(recs) {
+		t.Fatalf("expected %v dead letters, got %v", len(recs), len(got))
+	}
+	for idx := range recs {
+		if got[idx].Rule != recs[idx].Rule || got[idx].Line != recs[idx].Line {
+			t.Errorf("dead letter was incorrect, expected %+v, got %+v", recs[idx], got[idx])
+		}
+	}
+
+	if err := WriteDeadLetters(path, recs[1:]); err != nil {
+		t.Fatalf("got error writing dead letters: %v", err)
+	}
+	got, _ = ReadDeadLetters(path)
+	if len(got) != 1 || got[0].Rule != "two" {
+		t.Errorf("expected only the second dead letter, got %+v", got)
+	}
+}
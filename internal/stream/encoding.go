@@ -0,0 +1,229 @@
+package stream
+
+import (
+	"errors"
+	"io"
+	"unicode/utf8"
+)
+
+// Encoding is the character encoding of the lines a Stream reads, which are
+// transcoded to UTF-8 before they're matched.
+type Encoding int
+
+const (
+	// EncodingUTF8 reads the lines as they are.
+	EncodingUTF8 Encoding = iota
+	// EncodingLatin1 is ISO-8859-1.
+	EncodingLatin1
+	// EncodingWindows1252 is Latin-1 with printable characters in place
+	// of most of the C1 controls.
+	EncodingWindows1252
+	// EncodingUTF16LE and EncodingUTF16BE are UTF-16, without or after a
+	// byte order mark.
+	EncodingUTF16LE
+	EncodingUTF16BE
+)
+
+// Invalid is the policy for byte sequences that aren't valid in a Stream's
+// Encoding.
+type Invalid int
+
+const (
+	// InvalidPass keeps the bytes as they are. An unpaired UTF-16
+	// surrogate is kept as its three byte UTF-8 form.
+	InvalidPass Invalid = iota
+	// InvalidReplace replaces each invalid byte (or surrogate) with U+FFFD.
+	InvalidReplace
+	// InvalidDrop discards them.
+	InvalidDrop
+)
+
+// ParseEncoding returns the Encoding for its config name, an empty string
+// is EncodingUTF8.
+func ParseEncoding(str string) (Encoding, error) {
+	switch str {
+	case "", "utf-8", "utf8":
+		return EncodingUTF8, nil
+	case "latin1", "latin-1", "iso-8859-1":
+		return EncodingLatin1, nil
+	case "windows-1252", "cp1252":
+		return EncodingWindows1252, nil
+	case "utf-16le":
+		return EncodingUTF16LE, nil
+	case "utf-16be":
+		return EncodingUTF16BE, nil
+	}
+	return EncodingUTF8, errors.New("encoding must be one of utf-8, latin1, windows-1252, utf-16le or utf-16be")
+}
+
+// ParseInvalid returns the Invalid for its config name, an empty string is
+// InvalidPass.
+func ParseInvalid(str string) (Invalid, error) {
+	switch str {
+	case "", "pass":
+		return InvalidPass, nil
+	case "replace":
+		return InvalidReplace, nil
+	case "drop":
+		return InvalidDrop, nil
+	}
+	return InvalidPass, errors.New("invalid must be one of pass, replace or drop")
+}
+
+// windows1252 are the characters of Windows-1252 from 0x80 to 0x9f, where it
+// differs from Latin-1. Zero is a byte it doesn't define.
+var windows1252 = [32]rune{
+	'€', 0, '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', 0, 'Ž', 0,
+	0, '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', 0, 'ž', 'Ÿ',
+}
+
+// decoder transcodes what's read from rd to UTF-8. A character split across
+// reads is kept until the rest of it is read, so a file being followed can
+// be read from again after io.EOF.
+type decoder struct {
+	rd      io.Reader
+	enc     Encoding
+	invalid Invalid
+	started bool
+	in      []byte
+	out     []byte
+	buf     [4096]byte
+}
+
+// decode returns rd, transcoding it to UTF-8 from the Stream's Encoding. rd
+// is returned as it is when there's nothing to do.
+func (s *Stream) decode(rd io.Reader) io.Reader {
+	if s.encoding == EncodingUTF8 && s.invalid == InvalidPass {
+		return rd
+	}
+	return &decoder{rd: rd, enc: s.encoding, invalid: s.invalid}
+}
+
+// newline returns a newline in the Stream's Encoding.
+func (s *Stream) newline() []byte {
+	switch s.encoding {
+	case EncodingUTF16LE:
+		return []byte{'\n', 0}
+	case EncodingUTF16BE:
+		return []byte{0, '\n'}
+	}
+	return []byte{'\n'}
+}
+
+// Read reads the transcoded text.
+func (d *decoder) Read(p []byte) (int, error) {
+	for len(d.out) == 0 {
+		n, err := d.rd.Read(d.buf[:])
+		d.in = append(d.in, d.buf[:n]...)
+		d.transcode()
+		if len(d.out) == 0 && err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.out)
+	d.out = d.out[n:]
+	return n, nil
+}
+
+// transcode moves the complete characters read from d.in to d.out.
+func (d *decoder) transcode() {
+	in := d.in
+	if !d.started && len(in) >= 2 {
+		d.started = true
+		bom := d.enc == EncodingUTF16LE && in[0] == 0xff && in[1] == 0xfe ||
+			d.enc == EncodingUTF16BE && in[0] == 0xfe && in[1] == 0xff
+		if bom {
+			in = in[2:]
+		}
+	}
+
+	for len(in) > 0 {
+		switch d.enc {
+		case EncodingUTF8:
+			r, size := utf8.DecodeRune(in)
+			if r == utf8.RuneError && size == 1 {
+				if !utf8.FullRune(in) {
+					d.in = append(d.in[:0], in...)
+					return
+				}
+				d.bad(in[:1])
+			} else {
+				d.out = append(d.out, in[:size]...)
+			}
+			in = in[size:]
+		case EncodingLatin1:
+			d.out = appendRune(d.out, rune(in[0]))
+			in = in[1:]
+		case EncodingWindows1252:
+			r := rune(in[0])
+			if r >= 0x80 && r <= 0x9f {
+				if r = windows1252[r-0x80]; r == 0 {
+					// Passing through an undefined byte keeps it as
+					// its Latin-1 control character.
+					d.bad(appendRune(nil, rune(in[0])))
+					in = in[1:]
+					continue
+				}
+			}
+			d.out = appendRune(d.out, r)
+			in = in[1:]
+		case EncodingUTF16LE, EncodingUTF16BE:
+			if len(in) < 2 {
+				d.in = append(d.in[:0], in...)
+				return
+			}
+			u := d.unit(in)
+			switch {
+			case u >= 0xd800 && u < 0xdc00:
+				if len(in) < 4 {
+					d.in = append(d.in[:0], in...)
+					return
+				}
+				if low := d.unit(in[2:]); low >= 0xdc00 && low < 0xe000 {
+					r := 0x10000 + (rune(u)-0xd800)<<10 + rune(low) - 0xdc00
+					d.out = appendRune(d.out, r)
+					in = in[4:]
+					continue
+				}
+				d.bad(surrogate(u))
+			case u >= 0xdc00 && u < 0xe000:
+				d.bad(surrogate(u))
+			default:
+				d.out = appendRune(d.out, rune(u))
+			}
+			in = in[2:]
+		}
+	}
+	d.in = d.in[:0]
+}
+
+// unit returns the UTF-16 code unit at the start of in.
+func (d *decoder) unit(in []byte) uint16 {
+	if d.enc == EncodingUTF16BE {
+		return uint16(in[0])<<8 | uint16(in[1])
+	}
+	return uint16(in[1])<<8 | uint16(in[0])
+}
+
+// bad handles an invalid sequence with the decoder's policy, raw being what
+// it's passed through as.
+func (d *decoder) bad(raw []byte) {
+	switch d.invalid {
+	case InvalidPass:
+		d.out = append(d.out, raw...)
+	case InvalidReplace:
+		d.out = appendRune(d.out, utf8.RuneError)
+	}
+}
+
+// surrogate returns the three byte UTF-8 form of an unpaired surrogate.
+func surrogate(u uint16) []byte {
+	return []byte{0xe0 | byte(u>>12), 0x80 | byte(u>>6)&0x3f, 0x80 | byte(u)&0x3f}
+}
+
+// appendRune appends the UTF-8 encoding of r to b.
+func appendRune(b []byte, r rune) []byte {
+	var buf [utf8.UTFMax]byte
+	n := utf8.EncodeRune(buf[:], r)
+	return append(b, buf[:n]...)
+}
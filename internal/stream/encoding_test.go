@@ -0,0 +1,76 @@
+package stream
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestParseEncoding(t *testing.T) {
+	testTable := []struct {
+		str string
+		enc Encoding
+		err bool
+	}{
+		{str: "", enc: EncodingUTF8},
+		{str: "latin1", enc: EncodingLatin1},
+		{str: "iso-8859-1", enc: EncodingLatin1},
+		{str: "windows-1252", enc: EncodingWindows1252},
+		{str: "utf-16le", enc: EncodingUTF16LE},
+		{str: "utf-16be", enc: EncodingUTF16BE},
+		{str: "ebcdic", err: true},
+	}
+
+	for _, test := range testTable {
+		enc, err := ParseEncoding(test.str)
+		if (err != nil) != test.err {
+			t.Errorf("error for %q was incorrect, expected %v, got %v", test.str, test.err, err)
+		}
+		if enc != test.enc {
+			t.Errorf("encoding for %q was incorrect, expected %v, got %v", test.str, test.enc, enc)
+		}
+	}
+
+	for str, exp := range map[string]Invalid{"": InvalidPass, "pass": InvalidPass, "replace": InvalidReplace, "drop": InvalidDrop} {
+		if invalid, err := ParseInvalid(str); err != nil || invalid != exp {
+			t.Errorf("invalid for %q was incorrect, expected %v, got %v (%v)", str, exp, invalid, err)
+		}
+	}
+	if _, err := ParseInvalid("ignore"); err == nil {
+		t.Errorf("expected an error for an unknown invalid policy")
+	}
+}
+
+func TestDecode(t *testing.T) {
+	testTable := []struct {
+		enc     Encoding
+		invalid Invalid
+		in      string
+		exp     string
+	}{
+		{enc: EncodingUTF8, invalid: InvalidPass, in: "caf\xc3\xa9 \xff\n", exp: "caf\xc3\xa9 \xff\n"},
+		{enc: EncodingUTF8, invalid: InvalidReplace, in: "caf\xc3\xa9 \xff\n", exp: "café �\n"},
+		{enc: EncodingUTF8, invalid: InvalidDrop, in: "caf\xc3\xa9 \xff\n", exp: "café \n"},
+		{enc: EncodingLatin1, in: "caf\xe9 \x80\n", exp: "café \u0080\n"},
+		{enc: EncodingWindows1252, in: "\x93quoted\x94 \x80 \x81\n", exp: "“quoted” € \u0081\n"},
+		{enc: EncodingWindows1252, invalid: InvalidReplace, in: "\x81\n", exp: "�\n"},
+		{enc: EncodingUTF16LE, in: "\xff\xfeA\x00\xe9\x00\n\x00=\xd8\x00\xde\n\x00", exp: "Aé\n😀\n"},
+		{enc: EncodingUTF16BE, in: "\xfe\xff\x00A\x00\n", exp: "A\n"},
+		{enc: EncodingUTF16LE, invalid: InvalidPass, in: "\x00\xdcA\x00", exp: "\xed\xb0\x80A"},
+		{enc: EncodingUTF16LE, invalid: InvalidReplace, in: "=\xd8A\x00", exp: "�A"},
+		{enc: EncodingUTF16LE, invalid: InvalidDrop, in: "=\xd8A\x00", exp: "A"},
+	}
+
+	for _, test := range testTable {
+		s := &Stream{encoding: test.enc, invalid: test.invalid}
+		// Reading a byte at a time splits every character.
+		out, err := ioutil.ReadAll(s.decode(iotest.OneByteReader(strings.NewReader(test.in))))
+		if err != nil {
+			t.Errorf("got error decoding %q: %v", test.in, err)
+		}
+		if string(out) != test.exp {
+			t.Errorf("decoding %q was incorrect, expected %q, got %q", test.in, test.exp, out)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package stream
+
+import (
+	"strings"
+	"time"
+)
+
+// Event is a line read by a Stream, along with any named fields its source
+// gives it, such as the hostname of a syslog message. Named fields are used
+// with #{name} tokens, the same as the numbered fields of the line. Time is
+// when the event occurred, parsed from the line when the Stream has a
+// timestamp layout, otherwise when it was read. It's the #{ts} token.
+// Stamped is whether Time was parsed from the line.
+type Event struct {
+	Line    string
+	Fields  map[string]string
+	Time    time.Time
+	Stamped bool
+}
+
+// names returns the named fields of ev for its tokens, with ts as the time
+// it occurred unless it has a field of its own called ts.
+func (ev Event) names() map[string]string {
+	if ev.Time.IsZero() {
+		return ev.Fields
+	}
+	if _, ok := ev.Fields["ts"]; ok {
+		return ev.Fields
+	}
+	return withFields(ev, map[string]string{"ts": ev.Time.Format(time.RFC3339Nano)})
+}
+
+// when returns the time ev occurred, or now for an event that wasn't read
+// by a Stream.
+func (ev Event) when() time.Time {
+	if ev.Time.IsZero() {
+		return time.Now()
+	}
+	return ev.Time
+}
+
+// isFieldName reports whether str can be the name in a #{name} token.
+func isFieldName(str string) bool {
+	if str == "" {
+		return false
+	}
+	for i, r := range str {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '_':
+		case r >= '0' && r <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// insertNames replaces the #{name} tokens in str with the named fields. The
+// values are passed through escape, if it's not nil, before they're
+// inserted. Tokens for names that aren't in fields are left as they are.
+func insertNames(str string, fields map[string]string, escape func(string) string) string {
+	if len(fields) == 0 {
+		return str
+	}
+	return renderTokens(str, nil, fields, escape)
+}
+
+// renderTokens replaces the field tokens in tmpl with the values returned by
+// lookup, if it's not nil, and the #{name} tokens with the named fields, in
+// one pass so the text of a value is never read as a token itself. The
+// values are passed through escape, if it's not nil, and tokens without a
+// value are left as they are.
+func renderTokens(tmpl string, lookup func(int) (string, bool), names map[string]string, escape func(string) string) string {
+	if !strings.Contains(tmpl, `#{`) {
+		return tmpl
+	}
+	var sbuff strings.Builder
+	for i := 0; i < len(tmpl); i++ {
+		if tmpl[i] == '#' {
+			val, n, ok := "", 0, false
+			if field, fn, fok := fieldToken(tmpl[i:]); fok {
+				if lookup != nil {
+					val, ok = lookup(field)
+				}
+				n = fn
+			} else if name, nn, nok := nameToken(tmpl[i:]); nok {
+				val, ok = names[name]
+				n = nn
+			}
+			if ok {
+				if escape != nil {
+					val = escape(val)
+				}
+				sbuff.WriteString(val)
+				i += n - 1
+				continue
+			}
+		}
+		sbuff.WriteByte(tmpl[i])
+	}
+	return sbuff.String()
+}
@@ -0,0 +1,96 @@
+package stream
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIsFieldName(t *testing.T) {
+	testTable := []struct {
+		str string
+		exp bool
+	}{
+		{str: "hostname", exp: true},
+		{str: "app_name", exp: true},
+		{str: "field2", exp: true},
+		{str: "2", exp: false},
+		{str: "", exp: false},
+		{str: "app-name", exp: false},
+	}
+
+	for _, test := range testTable {
+		if resp := isFieldName(test.str); resp != test.exp {
+			t.Errorf("response for %q was incorrect, expected %v, got %v", test.str, test.exp, resp)
+		}
+	}
+}
+
+func TestInsertNames(t *testing.T) {
+	fields := map[string]string{
+		"hostname": "web01",
+		"message":  "a b&c",
+		"quote":    "#{hostname}",
+	}
+
+	testTable := []struct {
+		str    string
+		escape func(string) string
+		exp    string
+	}{
+		{
+			str: "host:#{hostname} msg:#{message}",
+			exp: "host:web01 msg:a b&c",
+		},
+		{
+			str:    "/alert?host=#{hostname}&msg=#{message}",
+			escape: urlEscape,
+			exp:    "/alert?host=web01&msg=a%20b%26c",
+		},
+		{
+			str: "#{app_name} #{1}",
+			exp: "#{app_name} #{1}",
+		},
+		{
+			str: "#{quote} #{hostname",
+			exp: "#{hostname} #{hostname",
+		},
+	}
+
+	for _, test := range testTable {
+		resp := insertNames(test.str, fields, test.escape)
+		if strings.Compare(resp, test.exp) != 0 {
+			t.Errorf("response string was incorrect, expected %v, got %v", test.exp, resp)
+		}
+	}
+}
+
+func TestTemplateNames(t *testing.T) {
+	tmpl := newTemplate("#{severity} from #{hostname}: #{2}", " ", nil)
+	ev := Event{
+		Line:   "disk full",
+		Fields: map[string]string{"severity": "crit", "hostname": "db01"},
+	}
+	exp := "crit from db01: full"
+	if resp := tmpl.render(ev); resp != exp {
+		t.Errorf("response string was incorrect, expected %v, got %v", exp, resp)
+	}
+}
+
+func TestRenderTokensOnce(t *testing.T) {
+	// The text of the line isn't read as tokens once it's inserted.
+	ev := Event{
+		Line:   "says #{hostname} #{1}",
+		Fields: map[string]string{"hostname": "db01", "message": "#{2}"},
+	}
+	tmpl := newTemplate("#{hostname}: #{2} #{message}", " ", nil)
+	exp := "db01: #{hostname} #{2}"
+	if resp := tmpl.render(ev); resp != exp {
+		t.Errorf("template was incorrect, expected %v, got %v", exp, resp)
+	}
+
+	s := &Stream{delim: " ", args: []string{"#{2}", "#{3}@#{hostname}"}}
+	args := prepEventArgs(ev, s)
+	if len(args) != 2 || args[0] != "#{hostname}" || args[1] != "#{1}@db01" {
+		t.Errorf("args were incorrect, expected [#{hostname} #{1}@db01], got %v", args)
+	}
+}
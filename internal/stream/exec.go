@@ -0,0 +1,174 @@
+package stream
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultOutputLimit is the most bytes of a command's stdout and stderr that
+// are kept when a Stream doesn't set its own limit.
+var DefaultOutputLimit = 4096
+
+// CommandResult holds what was run for a matched line and what it output.
+type CommandResult struct {
+	Command  string
+	Args     []string
+	ExitCode int
+	Stdout   string
+	Stderr   string
+	Duration time.Duration
+}
+
+// CommandError is returned when a Stream's command fails, holding what was
+// run and how it failed.
+type CommandError struct {
+	CommandResult
+	Err error
+}
+
+// Error returns the command, how it failed, and its output.
+func (e *CommandError) Error() string {
+	var sbuff strings.Builder
+	sbuff.WriteString(fmt.Sprintf("%s: %s (exit code %v, after %v)", e.Command, e.Err, e.ExitCode, e.Duration))
+	if e.Stderr != "" {
+		sbuff.WriteString(fmt.Sprintf(", stderr: %q", e.Stderr))
+	}
+	if e.Stdout != "" {
+		sbuff.WriteString(fmt.Sprintf(", stdout: %q", e.Stdout))
+	}
+	return sbuff.String()
+}
+
+// Unwrap returns the underlying error from running the command.
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+// limitBuffer keeps up to max bytes written to it, discarding the rest.
+type limitBuffer struct {
+	buf []byte
+	max int
+}
+
+// Write appends p to the buffer up to its limit, always reporting the whole
+// of p as written so the command isn't interrupted.
+func (b *limitBuffer) Write(p []byte) (int, error) {
+	if room := b.max - len(b.buf); room > 0 {
+		if len(p) > room {
+			b.buf = append(b.buf, p[:room]...)
+		} else {
+			b.buf = append(b.buf, p...)
+		}
+	}
+	return len(p), nil
+}
+
+// String returns the bytes kept by the buffer.
+func (b *limitBuffer) String() string {
+	return string(b.buf)
+}
+
+// outputLog appends the results of a Stream's commands to a log file.
+type outputLog struct {
+	lock sync.Mutex
+	file *os.File
+}
+
+// openOutputLog opens the log file at path for appending, creating it if it
+// doesn't exist.
+func openOutputLog(path string) (*outputLog, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &outputLog{file: file}, nil
+}
+
+// close closes the log file, once the Stream's commands have finished.
+func (l *outputLog) close() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if err := l.file.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "error closing output log %s: \n", err.Error())
+	}
+}
+
+// write logs a command's result and output as one block, so the output of
+// commands running at once isn't interleaved.
+func (l *outputLog) write(rule, matchLn string, res CommandResult, err error) {
+	status := "ok"
+	if err != nil {
+		status = err.Error()
+	}
+
+	var sbuff strings.Builder
+	sbuff.WriteString(fmt.Sprintf("%s %s exit=%v duration=%v status=%q line=%q command=%q args=%q\n",
+		time.Now().Format(time.RFC3339), rule, res.ExitCode, res.Duration, status, matchLn, res.Command, res.Args))
+	for _, out := range []struct{ name, text string }{{"stdout", res.Stdout}, {"stderr", res.Stderr}} {
+		if out.text == "" {
+			continue
+		}
+		for _, ln := range strings.Split(strings.TrimRight(out.text, "\n"), "\n") {
+			sbuff.WriteString(fmt.Sprintf("\t%s: %s\n", out.name, ln))
+		}
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if _, err := l.file.WriteString(sbuff.String()); err != nil {
+		fmt.Fprintf(os.Stderr, "error writing output log %s: \n", err.Error())
+	}
+}
+
+// execCommand runs the Stream's command once for a matched line.
+func (s *Stream) execCommand(ev Event) error {
+	// Before running the command, we need to replace field
+	// tokens with the actual matched line fields.
+	matchLn := ev.Line
+	name, args := s.cmd, []string{}
+	if s.shell {
+		name, args = ShellPath, []string{"-c", prepShell(ev, s)}
+	} else {
+		args = prepEventArgs(ev, s)
+	}
+	if LogDebug {
+		fmt.Printf("calling %s with args %v\n", name, args)
+	}
+
+	cmd := exec.Command(name, args...)
+	stdout := limitBuffer{max: s.outputLimit}
+	stderr := limitBuffer{max: s.outputLimit}
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	start := time.Now()
+	err := cmd.Run()
+	res := CommandResult{
+		Command:  name,
+		Args:     args,
+		ExitCode: cmd.ProcessState.ExitCode(),
+		Stdout:   stdout.String(),
+		Stderr:   stderr.String(),
+		Duration: time.Since(start),
+	}
+	if s.outputLog != nil {
+		s.outputLog.write(s.name, matchLn, res, err)
+	}
+	if err != nil {
+		res.Stdout = strings.TrimSpace(res.Stdout)
+		res.Stderr = strings.TrimSpace(res.Stderr)
+		return &CommandError{CommandResult: res, Err: err}
+	}
+
+	if LogDebug && res.Stdout != "" {
+		fmt.Printf("output: %s matched line: %s.\n", res.Stdout, matchLn)
+	}
+	if LogDebug && res.Stderr != "" {
+		fmt.Printf("stderr: %s matched line: %s.\n", res.Stderr, matchLn)
+	}
+	return nil
+}
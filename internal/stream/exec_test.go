@@ -0,0 +1,88 @@
+package stream
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLimitBuffer(t *testing.T) {
+	b := limitBuffer{max: 8}
+	for _, str := range []string{"abc", "defgh", "ijk"} {
+		n, err := b.Write([]byte(str))
+		if n != len(str) || err != nil {
+			t.Errorf("expected write of %v bytes, got %v, %v", len(str), n, err)
+		}
+	}
+	if b.String() != "abcdefgh" {
+		t.Errorf("expected buffer to hold abcdefgh, got %v", b.String())
+	}
+}
+
+func TestExecCommandOutput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "streammon")
+	if err != nil {
+		t.Fatalf("got error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	logPath := filepath.Join(dir, "output.log")
+
+	s, err := NewStream(
+		".*",
+		"sh",
+		" ",
+		"",
+		[]string{"-c", "echo connecting to #{2}; echo refused by #{2} >&2; exit #{1}"},
+		Options{Name: "redis", OutputLimit: 16, OutputLog: logPath},
+	)
+	if err != nil {
+		t.Fatalf("got error creating stream: %v", err)
+	}
+
+	if err := s.ExecStreamComm("0 localhost"); err != nil {
+		t.Errorf("got error executing command: %v", err)
+	}
+
+	err = s.ExecStreamComm("2 localhost")
+	var cmdErr *CommandError
+	if !errors.As(err, &cmdErr) {
+		t.Fatalf("expected a CommandError, got %v", err)
+	}
+	if cmdErr.ExitCode != 2 {
+		t.Errorf("expected exit code 2, got %v", cmdErr.ExitCode)
+	}
+	if cmdErr.Stdout != "connecting to lo" || cmdErr.Stderr != "refused by local" {
+		t.Errorf("expected output capped at 16 bytes, got %q and %q", cmdErr.Stdout, cmdErr.Stderr)
+	}
+	if cmdErr.Duration <= 0 {
+		t.Errorf("expected the command's duration, got %v", cmdErr.Duration)
+	}
+	for _, exp := range []string{"exit code 2", `stderr: "refused by local"`, `stdout: "connecting to lo"`} {
+		if !strings.Contains(err.Error(), exp) {
+			t.Errorf("expected error to contain %v, got %v", exp, err.Error())
+		}
+	}
+
+	b, err := ioutil.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("got error reading output log: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 6 {
+		t.Fatalf("expected 6 lines in the output log, got %q", lines)
+	}
+	if !strings.Contains(lines[0], "redis exit=0") || !strings.Contains(lines[3], "redis exit=2") {
+		t.Errorf("expected the exit codes to be logged, got %q", lines)
+	}
+	if lines[4] != "\tstdout: connecting to lo" || lines[5] != "\tstderr: refused by local" {
+		t.Errorf("expected the output to be logged, got %q", lines[4:])
+	}
+
+	s.Wait()
+	if _, err := s.outputLog.file.Stat(); err == nil {
+		t.Errorf("expected the output log to be closed once the stream ended")
+	}
+}
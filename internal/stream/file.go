@@ -0,0 +1,227 @@
+package stream
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileOptions configures a file that a record is appended to for each
+// matched line.
+type FileOptions struct {
+	// Path is the file to append to. It can contain field tokens, and
+	// strftime style dates (%Y, %m, %d, %H, %M, %S) for a file per day or
+	// hour, of when the line occurred. Fields have any / replaced so they
+	// can't change directory.
+	Path string
+	// Format is the record written for each line, "#{0}" when empty. A
+	// newline is added to each record.
+	Format string
+	// MaxSize rotates the file to Path.1 before it grows past this many
+	// bytes, 0 never rotates.
+	MaxSize int64
+	// MaxBackups is the number of rotated files kept, Path.1 being the
+	// newest. Older files are removed.
+	MaxBackups int
+	// SyncAlways fsyncs the file after every record, SyncInterval fsyncs
+	// it at most once per interval. Neither leaves it to the system.
+	SyncAlways   bool
+	SyncInterval time.Duration
+}
+
+// maxOpenFiles is the most files a file action keeps open, for paths that
+// alternate between lines.
+const maxOpenFiles = 8
+
+// fileAction appends a record to a file for each matched line.
+type fileAction struct {
+	lock   sync.Mutex
+	opts   FileOptions
+	path   template
+	format template
+	// files are the open files, the most recently written last.
+	files    []*appendFile
+	lastSync time.Time
+}
+
+// appendFile is a file a fileAction has open, and its size.
+type appendFile struct {
+	path string
+	file *os.File
+	size int64
+}
+
+// newFileAction creates the action from its options, with the fields split
+// on delim.
+func newFileAction(opts FileOptions, delim string) (*fileAction, error) {
+	if opts.Path == "" {
+		return nil, errors.New("a file action needs a path")
+	}
+	if opts.Format == "" {
+		opts.Format = "#{0}"
+	}
+	return &fileAction{
+		opts:   opts,
+		path:   newTemplate(opts.Path, delim, pathEscape),
+		format: newTemplate(opts.Format, delim, nil),
+	}, nil
+}
+
+// Run appends the record for a matched line, rotating the file first if it
+// would grow past its maximum size.
+func (a *fileAction) Run(ev Event) error {
+	// The dates are expanded before the fields are substituted, so a %
+	// in a field is written as it is.
+	path := a.path
+	path.text = strftime(path.text, ev.when())
+	rec := a.format.render(ev) + "\n"
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	f, err := a.get(path.render(ev))
+	if err != nil {
+		return err
+	}
+	if a.opts.MaxSize > 0 && f.size > 0 && f.size+int64(len(rec)) > a.opts.MaxSize {
+		if err := a.rotate(f); err != nil {
+			return err
+		}
+	}
+
+	n, err := f.file.WriteString(rec)
+	f.size += int64(n)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if a.opts.SyncAlways || a.opts.SyncInterval > 0 && now.Sub(a.lastSync) >= a.opts.SyncInterval {
+		a.lastSync = now
+		return f.file.Sync()
+	}
+	return nil
+}
+
+// get returns the open file for path, opening it if it isn't already and
+// closing the least recently written file if too many are open.
+func (a *fileAction) get(path string) (*appendFile, error) {
+	for i, f := range a.files {
+		if f.path == path {
+			a.files = append(append(a.files[:i], a.files[i+1:]...), f)
+			return f, nil
+		}
+	}
+
+	f := &appendFile{path: path}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	if len(a.files) >= maxOpenFiles {
+		a.files[0].file.Close()
+		a.files = a.files[1:]
+	}
+	a.files = append(a.files, f)
+	return f, nil
+}
+
+// open opens the file for appending, creating it and its directory if
+// needed.
+func (f *appendFile) open() error {
+	if err := os.MkdirAll(filepath.Dir(f.path), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	if LogDebug {
+		fmt.Printf("appending to %s\n", f.path)
+	}
+	f.file, f.size = file, info.Size()
+	return nil
+}
+
+// rotate renames the file to .1, shifting the older backups along and
+// removing any past MaxBackups, then opens a new file. The file is dropped
+// from the open files if that fails.
+func (a *fileAction) rotate(f *appendFile) error {
+	if err := a.rename(f); err != nil {
+		a.forget(f)
+		return err
+	}
+	if err := f.open(); err != nil {
+		a.forget(f)
+		return err
+	}
+	return nil
+}
+
+// rename closes the file and moves it aside as the newest backup.
+func (a *fileAction) rename(f *appendFile) error {
+	path := f.path
+	f.file.Close()
+
+	if a.opts.MaxBackups < 1 {
+		os.Remove(path)
+	} else {
+		os.Remove(fmt.Sprintf("%s.%v", path, a.opts.MaxBackups))
+		for i := a.opts.MaxBackups - 1; i > 0; i-- {
+			os.Rename(fmt.Sprintf("%s.%v", path, i), fmt.Sprintf("%s.%v", path, i+1))
+		}
+		if err := os.Rename(path, path+".1"); err != nil {
+			return err
+		}
+	}
+
+	if LogDebug {
+		fmt.Printf("rotated %s\n", path)
+	}
+	return nil
+}
+
+// forget drops a closed file from the open files.
+func (a *fileAction) forget(f *appendFile) {
+	for i := range a.files {
+		if a.files[i] == f {
+			a.files = append(a.files[:i], a.files[i+1:]...)
+			return
+		}
+	}
+}
+
+// pathEscape stops a field changing the directory of a templated path.
+func pathEscape(str string) string {
+	str = strings.Replace(str, "/", "_", -1)
+	if str == ".." {
+		return "__"
+	}
+	return str
+}
+
+// strftime replaces the %Y, %m, %d, %H, %M and %S directives in str with
+// the parts of t, and %% with %.
+func strftime(str string, t time.Time) string {
+	if !strings.Contains(str, "%") {
+		return str
+	}
+	return strings.NewReplacer(
+		"%%", "%",
+		"%Y", t.Format("2006"),
+		"%m", t.Format("01"),
+		"%d", t.Format("02"),
+		"%H", t.Format("15"),
+		"%M", t.Format("04"),
+		"%S", t.Format("05"),
+	).Replace(str)
+}
@@ -0,0 +1,189 @@
+package stream
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStrftime(t *testing.T) {
+	now := time.Date(2022, time.January, 5, 7, 8, 9, 0, time.UTC)
+	testTable := []struct {
+		str string
+		exp string
+	}{
+		{str: "/tmp/streammon.log", exp: "/tmp/streammon.log"},
+		{str: "/tmp/streammon-%Y-%m-%d.log", exp: "/tmp/streammon-2022-01-05.log"},
+		{str: "%H:%M:%S 100%%", exp: "07:08:09 100%"},
+	}
+
+	for _, test := range testTable {
+		resp := strftime(test.str, now)
+		if resp != test.exp {
+			t.Errorf("response string was incorrect, expected %v, got %v", test.exp, resp)
+		}
+	}
+}
+
+func TestFileAction(t *testing.T) {
+	dir, err := ioutil.TempDir("", "streammon")
+	if err != nil {
+		t.Fatalf("got error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := NewStream(".*", "", " ", "", []string{}, Options{
+		File: &FileOptions{
+			Path:       filepath.Join(dir, "#{1}", "dhcp-%Y.log"),
+			Format:     "ip:#{3} mac:#{5}",
+			SyncAlways: true,
+		},
+	})
+	if err != nil {
+		t.Fatalf("got error creating stream: %v", err)
+	}
+
+	lines := []string{
+		"br1 DHCPREQUEST 192.168.127.3 from 61:7c:db:fb:45:5e",
+		"br1 DHCPREQUEST 192.168.127.11 from a0:d0:33:a9:7b:49",
+		"../br2 DHCPREQUEST 10.0.0.2 from d1:87:f8:5f:9d:1f",
+		"br%Y DHCPREQUEST 10.0.0.3 from 5c:0e:8b:11:3a:27",
+	}
+	for _, line := range lines {
+		if err := s.ExecStreamComm(line); err != nil {
+			t.Fatalf("got error executing action: %v", err)
+		}
+	}
+	// A line's dates are when it occurred rather than when it's written.
+	ev := Event{Line: "br1 DHCPREQUEST 192.168.127.9 from 4e:21:9b:07:c2:aa", Time: time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)}
+	if err := s.ExecEvent(ev); err != nil {
+		t.Fatalf("got error executing action: %v", err)
+	}
+
+	year := time.Now().Format("2006")
+	testTable := []struct {
+		path string
+		exp  string
+	}{
+		{
+			path: filepath.Join(dir, "br1", "dhcp-"+year+".log"),
+			exp:  "ip:192.168.127.3 mac:61:7c:db:fb:45:5e\nip:192.168.127.11 mac:a0:d0:33:a9:7b:49\n",
+		},
+		{
+			path: filepath.Join(dir, ".._br2", "dhcp-"+year+".log"),
+			exp:  "ip:10.0.0.2 mac:d1:87:f8:5f:9d:1f\n",
+		},
+		{
+			path: filepath.Join(dir, "br%Y", "dhcp-"+year+".log"),
+			exp:  "ip:10.0.0.3 mac:5c:0e:8b:11:3a:27\n",
+		},
+		{
+			path: filepath.Join(dir, "br1", "dhcp-2019.log"),
+			exp:  "ip:192.168.127.9 mac:4e:21:9b:07:c2:aa\n",
+		},
+	}
+	for _, test := range testTable {
+		b, err := ioutil.ReadFile(test.path)
+		if err != nil {
+			t.Errorf("got error reading %v: %v", test.path, err)
+			continue
+		}
+		if string(b) != test.exp {
+			t.Errorf("file contents were incorrect, expected %q, got %q", test.exp, string(b))
+		}
+	}
+}
+
+func TestFileActionRotate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "streammon")
+	if err != nil {
+		t.Fatalf("got error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "out.log")
+
+	a, err := newFileAction(FileOptions{Path: path, MaxSize: 20, MaxBackups: 2}, " ")
+	if err != nil {
+		t.Fatalf("got error creating action: %v", err)
+	}
+
+	// Each record is 10 bytes, so there's 2 to a file.
+	for i := 0; i < 7; i++ {
+		if err := a.Run(Event{Line: fmt.Sprintf("record %02v", i)}); err != nil {
+			t.Fatalf("got error executing action: %v", err)
+		}
+	}
+
+	testTable := []struct {
+		path string
+		exp  []string
+	}{
+		{path: path, exp: []string{"record 06"}},
+		{path: path + ".1", exp: []string{"record 04", "record 05"}},
+		{path: path + ".2", exp: []string{"record 02", "record 03"}},
+	}
+	for _, test := range testTable {
+		b, err := ioutil.ReadFile(test.path)
+		if err != nil {
+			t.Errorf("got error reading %v: %v", test.path, err)
+			continue
+		}
+		if exp := strings.Join(test.exp, "\n") + "\n"; string(b) != exp {
+			t.Errorf("file contents of %v were incorrect, expected %q, got %q", test.path, exp, string(b))
+		}
+	}
+	if _, err := os.Stat(path + ".3"); err == nil {
+		t.Errorf("expected only 2 backups to be kept")
+	}
+}
+
+func TestFileActionOpenFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "streammon")
+	if err != nil {
+		t.Fatalf("got error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	a, err := newFileAction(FileOptions{Path: filepath.Join(dir, "#{1}.log")}, " ")
+	if err != nil {
+		t.Fatalf("got error creating action: %v", err)
+	}
+
+	// Alternating paths keep their files open, up to the most that are.
+	testTable := []struct {
+		lines []string
+		open  []string
+	}{
+		{lines: []string{"a 1", "b 1", "a 2", "b 2"}, open: []string{"a", "b"}},
+		{lines: []string{"c 1", "d 1", "e 1", "f 1", "g 1", "h 1", "i 1", "b 3"}, open: []string{"c", "d", "e", "f", "g", "h", "i", "b"}},
+	}
+	for _, test := range testTable {
+		for _, line := range test.lines {
+			if err := a.Run(Event{Line: line}); err != nil {
+				t.Fatalf("got error executing action: %v", err)
+			}
+		}
+		open := []string{}
+		for _, f := range a.files {
+			open = append(open, strings.TrimSuffix(filepath.Base(f.path), ".log"))
+		}
+		if fmt.Sprint(open) != fmt.Sprint(test.open) {
+			t.Errorf("open files were incorrect, expected %v, got %v", test.open, open)
+		}
+	}
+
+	for name, exp := range map[string]string{"a": "a 1\na 2\n", "b": "b 1\nb 2\nb 3\n", "i": "i 1\n"} {
+		b, err := ioutil.ReadFile(filepath.Join(dir, name+".log"))
+		if err != nil {
+			t.Errorf("got error reading %v: %v", name, err)
+			continue
+		}
+		if string(b) != exp {
+			t.Errorf("file contents of %v were incorrect, expected %q, got %q", name, exp, string(b))
+		}
+	}
+}
@@ -0,0 +1,297 @@
+package stream
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hpcloud/tail"
+)
+
+// followed is a file being tailed, which the tail reopens when it's moved
+// (or deleted) and reads from the start when it's truncated in place.
+type followed struct {
+	path string
+	// drain is how long a rotated file is still read after it's moved,
+	// for the lines written before the application reopens its log.
+	drain time.Duration
+	poll  time.Duration
+	// newline ends a line drained from a moved file that doesn't have one.
+	newline []byte
+
+	lock sync.Mutex
+	t    *tail.Tail
+	// file is the file the tail has open opened again, to drain it once
+	// it's moved, nil when there's no drain.
+	file *os.File
+	// drained is sent what's drained from a moved file, which is written
+	// out before the lines the tail reads from the new one.
+	drained chan []byte
+
+	// offset is how far into the file the tail's sent lines up to, where
+	// it's restarted from if it has to fall back to polling.
+	offset int64
+	// The tail splits lines on a newline byte, which leaves the second
+	// byte of a UTF-16LE newline at the start of the next line, so the
+	// last line wouldn't be read until another is written. When utf16le
+	// is set the byte is read from the file, with peek, at the offset,
+	// and skip is whether it's been sent already.
+	utf16le bool
+	peek    *os.File
+	skip    bool
+	// reset is sent when the tail reopens the file, reading it from the
+	// start.
+	reset chan struct{}
+}
+
+// follow publishes the lines of the file at path, with the path as a named
+// field, until stop is closed. A file that can't be watched with inotify
+// is polled instead.
+func (s *Stream) follow(path string, swr Publisher, stop <-chan struct{}) {
+	f := &followed{
+		path:    path,
+		drain:   s.drain,
+		poll:    time.Duration(atomic.LoadInt64(&pollInterval)),
+		newline: s.newline(),
+		drained: make(chan []byte),
+		utf16le: s.encoding == EncodingUTF16LE,
+		reset:   make(chan struct{}),
+	}
+	f.reopened()
+
+	conf := tail.Config{
+		Follow: true,
+		ReOpen: true,
+		Poll:   pollFile(path, s.watch),
+		Logger: tailLog{Logger: tail.DiscardingLogger, f: f},
+	}
+	for {
+		err := s.followTail(f, conf, swr, stop)
+		if err == nil {
+			return
+		}
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		if conf.Poll {
+			fmt.Fprintf(os.Stderr, "error reading %s: %s\n", path, err)
+			return
+		}
+		// Adding the inotify watch can fail once the tail's started,
+		// such as when there's a limit on them.
+		fmt.Fprintf(os.Stderr, "can't watch %s with inotify, polling it: %s\n", path, err)
+		conf.Poll = true
+		conf.Location = &tail.SeekInfo{Offset: f.offset, Whence: io.SeekStart}
+	}
+}
+
+// followTail tails the file until stop is closed, returning the error the
+// tail stopped with otherwise.
+func (s *Stream) followTail(f *followed, conf tail.Config, swr Publisher, stop <-chan struct{}) error {
+	t, err := tail.TailFile(f.path, conf)
+	if err != nil {
+		return err
+	}
+	f.lock.Lock()
+	f.t = t
+	f.lock.Unlock()
+
+	// The lines are read back through the Stream's encoding and line
+	// length policy, as they are from any other source.
+	pr, pw := io.Pipe()
+	copied := make(chan struct{})
+	go func() {
+		f.copy(t, pw)
+		close(copied)
+	}()
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-stop:
+			pr.Close()
+		case <-done:
+		}
+	}()
+	err = s.scanLines(pr, f.path, map[string]string{"path": f.path}, swr)
+	close(done)
+	pr.Close()
+	t.Stop()
+	t.Cleanup()
+	<-copied
+	if err == io.ErrClosedPipe {
+		return nil
+	}
+	return err
+}
+
+// copy writes the lines of the tail to w, with their newlines, and what's
+// drained from a moved file before the lines of the new one, until the tail
+// stops.
+func (f *followed) copy(t *tail.Tail, w *io.PipeWriter) {
+	defer func() {
+		if f.peek != nil {
+			f.peek.Close()
+			f.peek = nil
+		}
+	}()
+	for {
+		select {
+		case line, ok := <-t.Lines:
+			if !ok {
+				w.CloseWithError(t.Err())
+				return
+			}
+			if line.Err != nil {
+				fmt.Fprintf(os.Stderr, "error reading %s: %s\n", f.path, line.Err)
+				continue
+			}
+			f.lock.Lock()
+			missing := f.drain > 0 && f.file == nil
+			f.lock.Unlock()
+			if missing {
+				// The file didn't exist until the tail opened it.
+				f.reopened()
+			}
+			// The tail keeps sending lines until it's stopped, even
+			// once nothing's reading them.
+			w.Write(f.line(line.Text))
+		case data := <-f.drained:
+			if f.skip && len(data) > 0 && data[0] == 0 {
+				data = data[1:]
+			}
+			f.skip = false
+			w.Write(data)
+		case <-f.reset:
+			f.offset, f.skip = 0, false
+			if f.peek != nil {
+				f.peek.Close()
+				f.peek = nil
+			}
+		}
+	}
+}
+
+// line returns the bytes of a line the tail's read, with the newline it
+// split the line on.
+func (f *followed) line(text string) []byte {
+	at := f.offset + int64(len(text))
+	f.offset = at + 1
+	data := []byte(text + "\n")
+	if f.skip && data[0] == 0 {
+		data = data[1:]
+	}
+	f.skip = false
+	if !f.utf16le || at%2 != 0 {
+		return data
+	}
+
+	// The newline byte starts a UTF-16LE code unit, which is a newline
+	// when the byte after it is zero.
+	if f.peek == nil {
+		file, err := os.Open(f.path)
+		if err != nil {
+			return data
+		}
+		f.peek = file
+	}
+	var b [1]byte
+	if _, err := f.peek.ReadAt(b[:], at+1); err == nil && b[0] == 0 {
+		data, f.skip = append(data, 0), true
+	}
+	return data
+}
+
+// reopened opens the file again after the tail has, to drain it once it's
+// moved.
+func (f *followed) reopened() {
+	if f.drain <= 0 {
+		return
+	}
+	file, err := os.Open(f.path)
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.file != nil {
+		f.file.Close()
+	}
+	f.file = nil
+	if err == nil {
+		f.file = file
+	}
+}
+
+// drainRotated sends what's written to a moved file after the tail's read
+// up to, until it's gone a poll without growing or the drain time is up.
+// It's called by the tail before it reopens the file's path, when it's read
+// to the end of the moved file.
+func (f *followed) drainRotated() {
+	f.lock.Lock()
+	file, t := f.file, f.t
+	f.file = nil
+	f.lock.Unlock()
+	if file == nil || t == nil {
+		return
+	}
+	defer file.Close()
+
+	offset, err := t.Tell()
+	if err == nil {
+		_, err = file.Seek(offset, io.SeekStart)
+	}
+	var data []byte
+	if err == nil {
+		data, err = ioutil.ReadAll(file)
+	}
+	deadline := time.Now().Add(f.drain)
+	for err == nil && time.Now().Before(deadline) {
+		time.Sleep(f.poll)
+		var more []byte
+		if more, err = ioutil.ReadAll(file); len(more) == 0 {
+			break
+		}
+		data = append(data, more...)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error draining %s: %s\n", f.path, err)
+	}
+
+	if len(data) > 0 {
+		if !bytes.HasSuffix(data, f.newline) {
+			data = append(data, f.newline...)
+		}
+		f.drained <- data
+	}
+}
+
+// tailLog is the Logger of a followed file's tail, which is told each time
+// the tail reopens the file. The rotations are logged in our own words, and
+// a moved file is drained before it's reopened. Anything else the tail logs
+// is only logged with -l.
+type tailLog struct {
+	*log.Logger
+	f *followed
+}
+
+// Printf is called by the tail to log what it's doing.
+func (l tailLog) Printf(format string, v ...interface{}) {
+	switch {
+	case strings.HasPrefix(format, "Re-opening moved/deleted"):
+		fmt.Fprintf(os.Stderr, "%s was rotated, reopening it\n", l.f.path)
+		l.f.drainRotated()
+	case strings.HasPrefix(format, "Re-opening truncated"):
+		fmt.Fprintf(os.Stderr, "%s was truncated, reading it from the start\n", l.f.path)
+	case strings.HasPrefix(format, "Successfully reopened"):
+		l.f.reopened()
+		l.f.reset <- struct{}{}
+	case LogDebug:
+		fmt.Println(strings.TrimSpace(fmt.Sprintf(format, v...)))
+	}
+}
@@ -0,0 +1,107 @@
+package stream
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFollowRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "streammon")
+	if err != nil {
+		t.Fatalf("got error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	SetPollInterval(100 * time.Millisecond)
+	defer SetPollInterval(0)
+
+	path := filepath.Join(dir, "messages")
+	s, err := NewStream(".*", "echo", " ", path, []string{}, Options{Drain: time.Second})
+	if err != nil {
+		t.Fatalf("got error creating stream: %v", err)
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go s.follow(path, NewPublisher(s), stop)
+
+	expect := func(lines ...string) {
+		for _, line := range lines {
+			select {
+			case ev := <-s.lines:
+				if ev.Line != line || ev.Fields["path"] != path {
+					t.Errorf("expected %q, got %+v", line, ev)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("timed out waiting for %q", line)
+			}
+		}
+	}
+	appendTo := func(path, text string) *os.File {
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			t.Fatalf("got error opening %v: %v", path, err)
+		}
+		file.WriteString(text)
+		return file
+	}
+	// The tail only starts watching the file once it's read to its end.
+	settle := func() {
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// The file doesn't exist yet, and the last line isn't finished.
+	file := appendTo(path, "one\r\ntwo\nthr")
+	expect("one", "two")
+	settle()
+	file.WriteString("ee\n")
+	expect("three")
+	settle()
+
+	// Rename rotation, the old file is drained before the new one is read.
+	os.Rename(path, path+".1")
+	file.WriteString("four\nfive")
+	appendTo(path, "six\n").Close()
+	file.Close()
+	expect("four", "five", "six")
+	settle()
+
+	// Copytruncate rotation.
+	os.Truncate(path, 0)
+	appendTo(path, "7\n").Close()
+	expect("7")
+}
+
+func TestFollowUTF16LE(t *testing.T) {
+	dir, err := ioutil.TempDir("", "streammon")
+	if err != nil {
+		t.Fatalf("got error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "messages")
+	// The second line has Ċ, whose first byte is a newline byte.
+	if err := ioutil.WriteFile(path, []byte("\xff\xfea\x00\n\x00\n\x01b\x00\n\x00"), 0644); err != nil {
+		t.Fatalf("got error writing %v: %v", path, err)
+	}
+	s, err := NewStream(".*", "echo", " ", path, []string{}, Options{Encoding: EncodingUTF16LE})
+	if err != nil {
+		t.Fatalf("got error creating stream: %v", err)
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go s.follow(path, NewPublisher(s), stop)
+
+	// Neither line is followed by another one.
+	for _, line := range []string{"a", "Ċb"} {
+		select {
+		case ev := <-s.lines:
+			if ev.Line != line {
+				t.Errorf("expected %q, got %q", line, ev.Line)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("timed out waiting for %q", line)
+		}
+	}
+}
@@ -0,0 +1,292 @@
+package stream
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Format is how the lines read by a Stream are parsed into events.
+type Format int
+
+const (
+	// FormatLine reads each line as it is.
+	FormatLine Format = iota
+	// FormatJournal reads the JSON lines of journalctl -o json, with each
+	// of the entry's fields as a named field and MESSAGE as the line.
+	FormatJournal
+	// FormatDockerJSON reads the lines of Docker's json-file logs, with
+	// the container's log message as the line.
+	FormatDockerJSON
+	// FormatCRI reads the lines of Kubernetes CRI container logs, with the
+	// container's log message as the line.
+	FormatCRI
+)
+
+// ParseFormat returns the Format for its config name, an empty string is
+// FormatLine.
+func ParseFormat(str string) (Format, error) {
+	switch str {
+	case "", "line":
+		return FormatLine, nil
+	case "journal":
+		return FormatJournal, nil
+	case "docker-json":
+		return FormatDockerJSON, nil
+	case "cri":
+		return FormatCRI, nil
+	}
+	return FormatLine, errors.New("format must be one of line, journal, docker-json or cri")
+}
+
+// formatPublisher parses the lines a source publishes with the Stream's
+// Format, and their timestamps, before they're sent to any Subscribers.
+type formatPublisher struct {
+	Publisher
+	s      *Stream
+	format Format
+	ts     *timestamp
+
+	// partials are the container log messages that have been split over
+	// several lines, until their last line is read. They're kept by path
+	// and stream, as a glob's files are published to together.
+	lock     sync.Mutex
+	partials map[string]*partialEvent
+}
+
+// partialEvent is a container log message that's been split over several
+// lines, held to the Stream's max line length as it's joined.
+type partialEvent struct {
+	ev  Event
+	buf lineBuffer
+}
+
+// Publish sends a line to any Subscribers once it's parsed.
+func (p *formatPublisher) Publish(line string) {
+	p.PublishEvent(Event{Line: line})
+}
+
+// PublishEvent sends a line with named fields to any Subscribers once it's
+// parsed. A line that can't be parsed is sent as it is.
+func (p *formatPublisher) PublishEvent(ev Event) {
+	var err error
+	partial := false
+	switch p.format {
+	case FormatJournal:
+		ev, err = parseJournal(ev)
+	case FormatDockerJSON:
+		ev, partial, err = parseDockerJSON(ev)
+	case FormatCRI:
+		ev, partial, err = parseCRI(ev)
+	}
+	if err != nil {
+		if LogDebug {
+			fmt.Printf("error parsing line: %s\n", err)
+		}
+	} else if p.format == FormatDockerJSON || p.format == FormatCRI {
+		for _, ev := range p.join(ev, partial) {
+			p.publish(ev)
+		}
+		return
+	}
+	p.publish(ev)
+}
+
+// publish sends a parsed event to any Subscribers, with its timestamp.
+func (p *formatPublisher) publish(ev Event) {
+	if p.ts != nil {
+		if t, ok := p.ts.parse(ev); ok {
+			ev.Time, ev.Stamped = t, true
+		}
+	}
+	p.Publisher.PublishEvent(ev)
+}
+
+// join joins a container log message that's been split over several lines,
+// applying the Stream's policy to messages over the max line length. It
+// returns the events to publish, each with the fields of the message's
+// first line: none until its last line is read, unless it's being split.
+func (p *formatPublisher) join(ev Event, partial bool) []Event {
+	key := ev.Fields["path"] + "\x00" + ev.Fields["stream"]
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	prev := p.partials[key]
+	if prev == nil {
+		if !partial && len(ev.Line) <= p.s.maxLine {
+			return []Event{ev}
+		}
+		source := ev.Fields["path"]
+		if source == "" {
+			source = p.s.source()
+		}
+		prev = &partialEvent{ev: ev, buf: lineBuffer{s: p.s, source: source}}
+		if partial {
+			if p.partials == nil {
+				p.partials = map[string]*partialEvent{}
+			}
+			p.partials[key] = prev
+		}
+	}
+	if !partial {
+		delete(p.partials, key)
+	}
+	return prev.events(prev.buf.add([]byte(ev.Line), !partial))
+}
+
+// events returns the lines of a partial message as events with the fields
+// of its first line.
+func (pe *partialEvent) events(lines []string) []Event {
+	evs := make([]Event, 0, len(lines))
+	for _, line := range lines {
+		ev := pe.ev
+		ev.Line = line
+		evs = append(evs, ev)
+	}
+	return evs
+}
+
+// Close publishes any container log messages whose last line wasn't read,
+// before finishing the channel for any Subscribers.
+func (p *formatPublisher) Close() {
+	p.lock.Lock()
+	keys := make([]string, 0, len(p.partials))
+	for key := range p.partials {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var evs []Event
+	for _, key := range keys {
+		pe := p.partials[key]
+		evs = append(evs, pe.events(pe.buf.add(nil, true))...)
+	}
+	p.partials = nil
+	p.lock.Unlock()
+
+	for _, ev := range evs {
+		p.publish(ev)
+	}
+	p.Publisher.Close()
+}
+
+// formatted returns swr, parsing the lines published to it with the
+// Stream's Format and timestamp layout.
+func (s *Stream) formatted(swr Publisher) Publisher {
+	if s.format == FormatLine && s.timestamp == nil {
+		return swr
+	}
+	return &formatPublisher{Publisher: swr, s: s, format: s.format, ts: s.timestamp}
+}
+
+// withFields returns a copy of the fields of ev, with the extra fields.
+func withFields(ev Event, extra map[string]string) map[string]string {
+	fields := map[string]string{}
+	for name, val := range ev.Fields {
+		fields[name] = val
+	}
+	for name, val := range extra {
+		fields[name] = val
+	}
+	return fields
+}
+
+// parseDockerJSON parses a line of Docker's json-file logs, which is split
+// over several lines when the message doesn't end with a newline. The
+// message's stream (stdout or stderr) and timestamp are named fields.
+func parseDockerJSON(ev Event) (Event, bool, error) {
+	var rec struct {
+		Log    *string `json:"log"`
+		Stream string  `json:"stream"`
+		Time   string  `json:"time"`
+	}
+	if err := json.Unmarshal([]byte(ev.Line), &rec); err != nil {
+		return ev, false, err
+	}
+	if rec.Log == nil {
+		return ev, false, errors.New("docker log line has no log message")
+	}
+
+	ev.Line = *rec.Log
+	partial := !strings.HasSuffix(ev.Line, "\n")
+	if !partial {
+		ev.Line = strings.TrimSuffix(strings.TrimSuffix(ev.Line, "\n"), "\r")
+	}
+	ev.Fields = withFields(ev, map[string]string{"stream": rec.Stream, "timestamp": rec.Time})
+	return ev, partial, nil
+}
+
+// parseCRI parses a line of a CRI container log, which is the timestamp,
+// the stream, tags and the message. A P tag is a message that's continued
+// on the next line, F is its last line. The message's stream (stdout or
+// stderr) and timestamp are named fields.
+func parseCRI(ev Event) (Event, bool, error) {
+	parts := strings.SplitN(ev.Line, " ", 4)
+	if len(parts) < 3 {
+		return ev, false, errors.New("cri log line is too short")
+	}
+	msg := ""
+	if len(parts) == 4 {
+		msg = parts[3]
+	}
+	tag := strings.SplitN(parts[2], ":", 2)[0]
+	if tag != "P" && tag != "F" {
+		return ev, false, errors.New("cri log line has no P or F tag")
+	}
+
+	ev.Line = msg
+	ev.Fields = withFields(ev, map[string]string{"stream": parts[1], "timestamp": parts[0]})
+	return ev, tag == "P", nil
+}
+
+// parseJournal parses a journal entry in JSON, adding its fields to those of
+// ev. A field with several values has its first, and a binary field (an
+// array of bytes) is read as text.
+func parseJournal(ev Event) (Event, error) {
+	entry := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(ev.Line), &entry); err != nil {
+		return ev, err
+	}
+
+	fields := withFields(ev, nil)
+	for name, val := range entry {
+		if str, ok := journalValue(val); ok {
+			fields[name] = str
+		}
+	}
+	if msg, ok := fields["MESSAGE"]; ok {
+		ev.Line = msg
+	}
+	ev.Fields = fields
+	return ev, nil
+}
+
+// journalValue returns the text of a journal field's value, false if it's
+// null.
+func journalValue(val interface{}) (string, bool) {
+	switch v := val.(type) {
+	case string:
+		return v, true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case []interface{}:
+		if len(v) == 0 {
+			return "", true
+		}
+		// A field that isn't valid UTF-8 is an array of its bytes,
+		// otherwise the array is of the field's values.
+		if _, ok := v[0].(float64); ok {
+			data := make([]byte, 0, len(v))
+			for _, b := range v {
+				n, _ := b.(float64)
+				data = append(data, byte(n))
+			}
+			return string(data), true
+		}
+		return journalValue(v[0])
+	}
+	return "", false
+}
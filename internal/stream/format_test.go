@@ -0,0 +1,244 @@
+package stream
+
+import (
+	"fmt"
+	"testing"
+)
+
+// journalEntries are lines recorded from journalctl -o json.
+var journalEntries = []string{
+	`{"__CURSOR":"s=6a1b;i=1f3","__REALTIME_TIMESTAMP":"1697061255003184","PRIORITY":"6","_PID":"4721","_SYSTEMD_UNIT":"sshd.service","SYSLOG_IDENTIFIER":"sshd","MESSAGE":"Failed password for root from 10.0.0.9 port 52144 ssh2"}`,
+	`{"PRIORITY":"3","_SYSTEMD_UNIT":"app.service","MESSAGE":[112,97,110,105,99,27,91,48,109],"CODE_LINE":null,"TAG":["a","b"]}`,
+	`-- No entries --`,
+}
+
+func TestParseFormat(t *testing.T) {
+	testTable := []struct {
+		str    string
+		format Format
+		err    bool
+	}{
+		{str: "", format: FormatLine},
+		{str: "line", format: FormatLine},
+		{str: "journal", format: FormatJournal},
+		{str: "docker-json", format: FormatDockerJSON},
+		{str: "cri", format: FormatCRI},
+		{str: "export", err: true},
+	}
+
+	for _, test := range testTable {
+		format, err := ParseFormat(test.str)
+		if (err != nil) != test.err {
+			t.Errorf("error for %q was incorrect, expected %v, got %v", test.str, test.err, err)
+		}
+		if format != test.format {
+			t.Errorf("format for %q was incorrect, expected %v, got %v", test.str, test.format, format)
+		}
+	}
+}
+
+func TestParseJournal(t *testing.T) {
+	testTable := []struct {
+		line   string
+		exp    string
+		fields map[string]string
+		err    bool
+	}{
+		{
+			line: journalEntries[0],
+			exp:  "Failed password for root from 10.0.0.9 port 52144 ssh2",
+			fields: map[string]string{
+				"path":              "/dev/stdin",
+				"__CURSOR":          "s=6a1b;i=1f3",
+				"PRIORITY":          "6",
+				"_SYSTEMD_UNIT":     "sshd.service",
+				"SYSLOG_IDENTIFIER": "sshd",
+			},
+		},
+		{
+			line: journalEntries[1],
+			exp:  "panic\x1b[0m",
+			fields: map[string]string{
+				"PRIORITY": "3",
+				"TAG":      "a",
+			},
+		},
+		{
+			line:   journalEntries[2],
+			exp:    journalEntries[2],
+			fields: map[string]string{"path": "/dev/stdin"},
+			err:    true,
+		},
+	}
+
+	for _, test := range testTable {
+		ev, err := parseJournal(Event{Line: test.line, Fields: map[string]string{"path": "/dev/stdin"}})
+		if (err != nil) != test.err {
+			t.Errorf("error was incorrect, expected %v, got %v", test.err, err)
+		}
+		if ev.Line != test.exp {
+			t.Errorf("line was incorrect, expected %q, got %q", test.exp, ev.Line)
+		}
+		for name, val := range test.fields {
+			if ev.Fields[name] != val {
+				t.Errorf("field %s was incorrect, expected %q, got %q", name, val, ev.Fields[name])
+			}
+		}
+		if _, ok := ev.Fields["CODE_LINE"]; ok {
+			t.Errorf("expected no field for a null value")
+		}
+	}
+}
+
+func TestMatchFields(t *testing.T) {
+	s, err := NewStream("password", "echo", " ", "", []string{}, Options{
+		Format: FormatJournal,
+		Match:  map[string]string{"_SYSTEMD_UNIT": `^sshd\.service$`, "PRIORITY": "^[0-6]$"},
+	})
+	if err != nil {
+		t.Fatalf("got error creating stream: %v", err)
+	}
+	s.lines = make(chan Event, len(journalEntries))
+	swr := s.formatted(NewPublisher(s))
+	for _, line := range journalEntries {
+		swr.Publish(line)
+	}
+
+	for i, exp := range []bool{true, false, false} {
+		ev := <-s.lines
+		if s.Match(ev) != exp {
+			t.Errorf("match of entry %v was incorrect, expected %v, got %v", i, exp, !exp)
+		}
+	}
+
+	if _, err := NewStream(".*", "echo", " ", "", []string{}, Options{Match: map[string]string{"PRIORITY": "["}}); err == nil {
+		t.Errorf("expected an error for an invalid field regexp")
+	}
+}
+
+func TestContainerFormats(t *testing.T) {
+	testTable := []struct {
+		format Format
+		lines  []Event
+		exp    []Event
+	}{
+		{
+			format: FormatDockerJSON,
+			lines: []Event{
+				{Line: `{"log":"listening on :8080\n","stream":"stdout","time":"2023-10-11T22:14:15.003Z"}`, Fields: map[string]string{"path": "a-json.log"}},
+				{Line: `{"log":"a very ","stream":"stderr","time":"2023-10-11T22:14:16Z"}`, Fields: map[string]string{"path": "a-json.log"}},
+				{Line: `{"log":"other\r\n","stream":"stdout","time":"2023-10-11T22:14:16.5Z"}`, Fields: map[string]string{"path": "a-json.log"}},
+				{Line: `{"log":"long line\n","stream":"stderr","time":"2023-10-11T22:14:17Z"}`, Fields: map[string]string{"path": "a-json.log"}},
+				{Line: `not json`, Fields: map[string]string{"path": "a-json.log"}},
+			},
+			exp: []Event{
+				{Line: "listening on :8080", Fields: map[string]string{"stream": "stdout", "timestamp": "2023-10-11T22:14:15.003Z"}},
+				{Line: "other", Fields: map[string]string{"stream": "stdout", "timestamp": "2023-10-11T22:14:16.5Z"}},
+				{Line: "a very long line", Fields: map[string]string{"stream": "stderr", "timestamp": "2023-10-11T22:14:16Z"}},
+				{Line: "not json", Fields: map[string]string{"path": "a-json.log"}},
+			},
+		},
+		{
+			format: FormatCRI,
+			lines: []Event{
+				{Line: "2023-10-11T22:14:15.003000000Z stdout F listening on :8080", Fields: map[string]string{"path": "0.log"}},
+				{Line: "2023-10-11T22:14:16.000000000Z stderr P a very ", Fields: map[string]string{"path": "0.log"}},
+				{Line: "2023-10-11T22:14:16.100000000Z stderr P long", Fields: map[string]string{"path": "1.log"}},
+				{Line: "2023-10-11T22:14:17.000000000Z stderr F long line", Fields: map[string]string{"path": "0.log"}},
+				{Line: "2023-10-11T22:14:18.000000000Z stdout F", Fields: map[string]string{"path": "0.log"}},
+			},
+			exp: []Event{
+				{Line: "listening on :8080", Fields: map[string]string{"stream": "stdout", "path": "0.log"}},
+				{Line: "a very long line", Fields: map[string]string{"stream": "stderr", "timestamp": "2023-10-11T22:14:16.000000000Z"}},
+				{Line: "", Fields: map[string]string{"stream": "stdout"}},
+			},
+		},
+	}
+
+	for _, test := range testTable {
+		s, err := NewStream(".*", "echo", " ", "", []string{}, Options{Format: test.format})
+		if err != nil {
+			t.Fatalf("got error creating stream: %v", err)
+		}
+		s.lines = make(chan Event, len(test.lines))
+		swr := s.formatted(NewPublisher(s))
+		for _, ev := range test.lines {
+			swr.PublishEvent(ev)
+		}
+
+		if len(s.lines) != len(test.exp) {
+			t.Errorf("format %v: expected %v events, got %v", test.format, len(test.exp), len(s.lines))
+			continue
+		}
+		for _, exp := range test.exp {
+			ev := <-s.lines
+			if ev.Line != exp.Line {
+				t.Errorf("format %v: line was incorrect, expected %q, got %q", test.format, exp.Line, ev.Line)
+			}
+			for name, val := range exp.Fields {
+				if ev.Fields[name] != val {
+					t.Errorf("format %v: field %s was incorrect, expected %q, got %q", test.format, name, val, ev.Fields[name])
+				}
+			}
+		}
+	}
+}
+
+func TestContainerLongLines(t *testing.T) {
+	// A message split over partial lines is held to the max line length
+	// as it's joined, and one whose last line isn't read is published
+	// when the source closes.
+	lines := []Event{
+		{Line: "2023-10-11T22:14:16.000000000Z stderr P abcdef", Fields: map[string]string{"path": "0.log"}},
+		{Line: "2023-10-11T22:14:16.100000000Z stderr P ghijkl", Fields: map[string]string{"path": "0.log"}},
+		{Line: "2023-10-11T22:14:16.200000000Z stderr F mnop", Fields: map[string]string{"path": "0.log"}},
+		{Line: "2023-10-11T22:14:17.000000000Z stdout F abcdefghij", Fields: map[string]string{"path": "0.log"}},
+		{Line: "2023-10-11T22:14:18.000000000Z stdout P unfinished", Fields: map[string]string{"path": "1.log"}},
+	}
+	testTable := []struct {
+		long  LongLines
+		lines []string
+		count int64
+	}{
+		{
+			long:  LongLinesTruncate,
+			lines: []string{"abcdefgh", "abcdefgh", "unfinish"},
+			count: 3,
+		},
+		{
+			long:  LongLinesSkip,
+			lines: []string{},
+			count: 3,
+		},
+		{
+			long:  LongLinesSplit,
+			lines: []string{"abcdefgh", "ijklmnop", "abcdefgh", "ij", "unfinish", "ed"},
+			count: 0,
+		},
+	}
+
+	for _, test := range testTable {
+		s, err := NewStream(".*", "echo", " ", "", []string{}, Options{Format: FormatCRI, MaxLine: 8, LongLines: test.long})
+		if err != nil {
+			t.Fatalf("got error creating stream: %v", err)
+		}
+		s.lines = make(chan Event, len(test.lines)+1)
+		swr := s.formatted(NewPublisher(s))
+		for _, ev := range lines {
+			swr.PublishEvent(ev)
+		}
+		addSub() // closing the publisher takes a subscriber from the count
+		swr.Close()
+
+		var got []string
+		for ev := range s.lines {
+			got = append(got, ev.Line)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(test.lines) {
+			t.Errorf("policy %v: expected %q, got %q", test.long, test.lines, got)
+		}
+		if s.LongLines() != test.count {
+			t.Errorf("policy %v: expected %v long lines, got %v", test.long, test.count, s.LongLines())
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package stream
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DefaultScanInterval is how often a Stream watching a glob or a directory
+// looks for new files when it doesn't set its own interval.
+var DefaultScanInterval = 10 * time.Second
+
+// globPattern returns the pattern of the files to tail when the Stream's
+// file is a glob or a directory, which are watched for new files.
+func globPattern(file string) (string, bool) {
+	if strings.ContainsAny(file, "*?[") {
+		return file, true
+	}
+	if info, err := os.Stat(file); err == nil && info.IsDir() {
+		return filepath.Join(file, "*"), true
+	}
+	return "", false
+}
+
+// globTails are the files matching a glob that are being tailed, by their
+// paths.
+type globTails struct {
+	stops map[string]chan struct{}
+	// infos are the files being tailed, as they were at the last scan, to
+	// know them once they've been moved aside.
+	infos map[string]os.FileInfo
+	// rotated are the files matching the glob that are rotated copies of
+	// ones being tailed, which aren't read again.
+	rotated map[string]bool
+	scanned bool
+}
+
+// newGlobTails returns globTails with nothing being tailed.
+func newGlobTails() *globTails {
+	return &globTails{
+		stops:   map[string]chan struct{}{},
+		infos:   map[string]os.FileInfo{},
+		rotated: map[string]bool{},
+	}
+}
+
+// stop stops tailing all of the files.
+func (g *globTails) stop() {
+	for path, stop := range g.stops {
+		close(stop)
+		delete(g.stops, path)
+	}
+}
+
+// rotatedCopy returns the file being tailed that path is a rotated copy of,
+// either because it is that file moved aside, or because it's named after
+// it with a number or date, as logrotate names them, and maybe compressed.
+func (g *globTails) rotatedCopy(path string) (string, bool) {
+	info, err := os.Stat(path)
+	for tailed, tailedInfo := range g.infos {
+		if err == nil && os.SameFile(info, tailedInfo) {
+			return tailed, true
+		}
+		if filepath.Dir(path) != filepath.Dir(tailed) {
+			continue
+		}
+		rest := strings.TrimPrefix(filepath.Base(path), filepath.Base(tailed))
+		if len(rest) < 2 || rest[0] != '.' && rest[0] != '-' {
+			continue
+		}
+		suffix := strings.SplitN(rest[1:], ".", 2)[0]
+		if suffix != "" && strings.Trim(suffix, "0123456789-_") == "" {
+			return tailed, true
+		}
+	}
+	return "", false
+}
+
+// watchGlob tails every file matching pattern, looking for new files every
+// scan interval. Files that are deleted stop being tailed.
+func (s *Stream) watchGlob(pattern string, swr Publisher) {
+	tails := newGlobTails()
+	ticker := time.NewTicker(s.scanInterval)
+	defer ticker.Stop()
+	for {
+		s.scanGlob(pattern, tails, swr)
+		<-ticker.C
+	}
+}
+
+// globFiles returns the regular files matching pattern.
+func globFiles(pattern string) []string {
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error matching %s: %s\n", pattern, err)
+		return nil
+	}
+	var files []string
+	for _, path := range matches {
+		if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+			files = append(files, path)
+		}
+	}
+	return files
+}
+
+// scanGlob starts tailing the files matching pattern that aren't in tails,
+// and stops tailing those that no longer exist. Once the first scan's done,
+// a new file that's a rotated copy of one being tailed isn't read, as its
+// lines have been already.
+func (s *Stream) scanGlob(pattern string, tails *globTails, swr Publisher) {
+	found := map[string]bool{}
+	for _, path := range globFiles(pattern) {
+		found[path] = true
+		if tails.stops[path] != nil || tails.rotated[path] {
+			continue
+		}
+		if tailed, ok := tails.rotatedCopy(path); ok && tails.scanned {
+			if LogDebug {
+				fmt.Printf("%s is a rotated copy of %s, not tailing it\n", path, tailed)
+			}
+			tails.rotated[path] = true
+			continue
+		}
+		stop := make(chan struct{})
+		tails.stops[path] = stop
+
+		// A compressed file is an archived log, it's read once.
+		if format := compression(path); format != "" {
+			if LogDebug {
+				fmt.Printf("reading %s compressed %s\n", format, path)
+			}
+			go func(path string) {
+				if err := s.readFile(path, swr); err != nil {
+					fmt.Fprintf(os.Stderr, "error reading %s: %s\n", path, err)
+				}
+			}(path)
+			continue
+		}
+		if LogDebug {
+			fmt.Printf("tailing %s\n", path)
+		}
+		go s.follow(path, swr, stop)
+	}
+
+	for path, stop := range tails.stops {
+		if !found[path] {
+			if LogDebug {
+				fmt.Printf("%s was removed, no longer tailing it\n", path)
+			}
+			close(stop)
+			delete(tails.stops, path)
+		}
+	}
+	for path := range tails.rotated {
+		if !found[path] {
+			delete(tails.rotated, path)
+		}
+	}
+
+	// The files are kept as they are now, for the next scan to know them
+	// once they're moved.
+	for path := range tails.infos {
+		delete(tails.infos, path)
+	}
+	for path := range tails.stops {
+		if info, err := os.Stat(path); err == nil {
+			tails.infos[path] = info
+		}
+	}
+	tails.scanned = true
+}
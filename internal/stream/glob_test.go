@@ -0,0 +1,129 @@
+package stream
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestGlobPattern(t *testing.T) {
+	dir, err := ioutil.TempDir("", "streammon")
+	if err != nil {
+		t.Fatalf("got error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	testTable := []struct {
+		file    string
+		pattern string
+		ok      bool
+	}{
+		{file: "/var/log/app/*.log", pattern: "/var/log/app/*.log", ok: true},
+		{file: "/var/log/app-[0-9].log", pattern: "/var/log/app-[0-9].log", ok: true},
+		{file: dir, pattern: filepath.Join(dir, "*"), ok: true},
+		{file: filepath.Join(dir, "missing.log"), ok: false},
+		{file: "", ok: false},
+	}
+
+	for _, test := range testTable {
+		pattern, ok := globPattern(test.file)
+		if pattern != test.pattern || ok != test.ok {
+			t.Errorf("pattern for %v was incorrect, expected %v, got %v", test.file, test.pattern, pattern)
+		}
+	}
+}
+
+func TestScanGlob(t *testing.T) {
+	dir, err := ioutil.TempDir("", "streammon")
+	if err != nil {
+		t.Fatalf("got error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	os.Mkdir(filepath.Join(dir, "archive.log"), 0755)
+
+	s, err := NewStream(".*", "echo", " ", filepath.Join(dir, "*.log"), []string{}, Options{})
+	if err != nil {
+		t.Fatalf("got error creating stream: %v", err)
+	}
+	swr := NewPublisher(s)
+	tails := newGlobTails()
+	defer tails.stop()
+
+	expect := func(path, line string) {
+		select {
+		case ev := <-s.lines:
+			if ev.Line != line || ev.Fields["path"] != path {
+				t.Errorf("expected %v from %v, got %+v", line, path, ev)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %v", line)
+		}
+	}
+
+	first := filepath.Join(dir, "app-01.log")
+	ioutil.WriteFile(first, []byte("first line\n"), 0644)
+	s.scanGlob(s.file, tails, swr)
+	if len(tails.stops) != 1 || tails.stops[first] == nil {
+		t.Fatalf("expected to tail only %v, got %v", first, tails.stops)
+	}
+	expect(first, "first line")
+
+	second := filepath.Join(dir, "app-02.log")
+	ioutil.WriteFile(second, []byte("second line\n"), 0644)
+	os.Remove(first)
+	s.scanGlob(s.file, tails, swr)
+	if len(tails.stops) != 1 || tails.stops[second] == nil {
+		t.Fatalf("expected to tail only %v, got %v", second, tails.stops)
+	}
+	expect(second, "second line")
+}
+
+func TestScanGlobRotated(t *testing.T) {
+	dir, err := ioutil.TempDir("", "streammon")
+	if err != nil {
+		t.Fatalf("got error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := NewStream(".*", "echo", " ", dir, []string{}, Options{})
+	if err != nil {
+		t.Fatalf("got error creating stream: %v", err)
+	}
+	swr := NewPublisher(s)
+	tails := newGlobTails()
+	defer tails.stop()
+	pattern := filepath.Join(dir, "*")
+
+	path := filepath.Join(dir, "app.log")
+	ioutil.WriteFile(path, []byte("first line\n"), 0644)
+	s.scanGlob(pattern, tails, swr)
+	select {
+	case ev := <-s.lines:
+		if ev.Line != "first line" {
+			t.Errorf("expected %q, got %+v", "first line", ev)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for %q", "first line")
+	}
+
+	// logrotate moves the file aside and compresses the one before it.
+	os.Rename(path, path+".1")
+	ioutil.WriteFile(path+".2.gz", []byte("\x1f\x8b"), 0644)
+	ioutil.WriteFile(path, nil, 0644)
+	other := filepath.Join(dir, "db.log")
+	ioutil.WriteFile(other, nil, 0644)
+	s.scanGlob(pattern, tails, swr)
+	if len(tails.stops) != 2 || tails.stops[path] == nil || tails.stops[other] == nil {
+		t.Errorf("expected to tail only %v and %v, got %v", path, other, tails.stops)
+	}
+	if len(tails.rotated) != 2 || !tails.rotated[path+".1"] || !tails.rotated[path+".2.gz"] {
+		t.Errorf("expected the rotated copies to be skipped, got %v", tails.rotated)
+	}
+	select {
+	case ev := <-s.lines:
+		t.Errorf("expected no lines read again, got %+v", ev)
+	case <-time.After(500 * time.Millisecond):
+	}
+}
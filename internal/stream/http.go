@@ -0,0 +1,201 @@
+package stream
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultHTTPTimeout is the timeout for an HTTP action's request when the
+// action doesn't set its own.
+var DefaultHTTPTimeout = 10 * time.Second
+
+// HTTPOptions configures an HTTP request made for each matched line. The
+// URL, headers and body can contain field tokens.
+type HTTPOptions struct {
+	Method  string
+	URL     string
+	Headers map[string]string
+	Body    string
+	Timeout time.Duration
+
+	// InsecureSkipVerify accepts any certificate from the server.
+	InsecureSkipVerify bool
+	// CAFile is a PEM file of the certificates to trust instead of the
+	// system's.
+	CAFile string
+	// CertFile and KeyFile are a PEM client certificate and key.
+	CertFile string
+	KeyFile  string
+
+	// SuccessCodes are the response statuses that mean the request
+	// worked, any 2xx status when empty.
+	SuccessCodes []int
+}
+
+// HTTPError is returned when an HTTP action gets a response status that
+// isn't a success.
+type HTTPError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Body       string
+}
+
+// Error returns the request and the response status.
+func (e *HTTPError) Error() string {
+	if e.Body != "" {
+		return fmt.Sprintf("%s %s: %s: %q", e.Method, e.URL, http.StatusText(e.StatusCode), e.Body)
+	}
+	return fmt.Sprintf("%s %s: %s", e.Method, e.URL, http.StatusText(e.StatusCode))
+}
+
+// httpAction makes an HTTP request for each matched line.
+type httpAction struct {
+	method  string
+	url     template
+	headers map[string]template
+	body    template
+	success []int
+	limit   int
+	client  *http.Client
+}
+
+// newHTTPAction creates the action from its options, with the fields split
+// on delim.
+func newHTTPAction(opts HTTPOptions, delim string, limit int) (*httpAction, error) {
+	if opts.URL == "" {
+		return nil, errors.New("an http action needs a url")
+	}
+
+	a := httpAction{
+		method:  strings.ToUpper(opts.Method),
+		url:     newTemplate(opts.URL, delim, urlEscape),
+		headers: map[string]template{},
+		success: opts.SuccessCodes,
+		limit:   limit,
+	}
+	if a.method == "" {
+		a.method = http.MethodPost
+	}
+
+	// Fields in a JSON body are escaped so they can sit inside a string.
+	var bodyEscape func(string) string
+	for name, val := range opts.Headers {
+		a.headers[name] = newTemplate(val, delim, nil)
+		if strings.EqualFold(name, "Content-Type") && strings.Contains(val, "json") {
+			bodyEscape = jsonEscape
+		}
+	}
+	a.body = newTemplate(opts.Body, delim, bodyEscape)
+
+	tlsConf, err := opts.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = DefaultHTTPTimeout
+	}
+	a.client = &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			TLSClientConfig:     tlsConf,
+			MaxIdleConnsPerHost: 4,
+		},
+	}
+	return &a, nil
+}
+
+// tlsConfig builds the TLS config for the options' certificate settings.
+func (opts HTTPOptions) tlsConfig() (*tls.Config, error) {
+	conf := tls.Config{InsecureSkipVerify: opts.InsecureSkipVerify}
+	if opts.CAFile != "" {
+		pem, err := ioutil.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.RootCAs = x509.NewCertPool()
+		if !conf.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", opts.CAFile)
+		}
+	}
+	if opts.CertFile != "" || opts.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	return &conf, nil
+}
+
+// Run makes the request for a matched line.
+func (a *httpAction) Run(ev Event) error {
+	var body io.Reader
+	if a.body.text != "" {
+		body = strings.NewReader(a.body.render(ev))
+	}
+	url := a.url.render(ev)
+	req, err := http.NewRequest(a.method, url, body)
+	if err != nil {
+		return err
+	}
+
+	// Set the headers in a fixed order, so requests are repeatable.
+	names := make([]string, 0, len(a.headers))
+	for name := range a.headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		req.Header.Set(name, a.headers[name].render(ev))
+	}
+
+	if LogDebug {
+		fmt.Printf("calling %s %s\n", a.method, url)
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// Read the body so the connection can be reused.
+	respBody := limitBuffer{max: a.limit}
+	io.Copy(&respBody, resp.Body)
+
+	if !a.succeeded(resp.StatusCode) {
+		return &HTTPError{
+			Method:     a.method,
+			URL:        url,
+			StatusCode: resp.StatusCode,
+			Body:       strings.TrimSpace(respBody.String()),
+		}
+	}
+	if LogDebug && respBody.String() != "" {
+		fmt.Printf("output: %s matched line: %s.\n", respBody.String(), ev.Line)
+	}
+	return nil
+}
+
+// succeeded reports whether the response status means the request worked.
+func (a *httpAction) succeeded(code int) bool {
+	if len(a.success) == 0 {
+		return code >= 200 && code < 300
+	}
+	for _, c := range a.success {
+		if code == c {
+			return true
+		}
+	}
+	return false
+}
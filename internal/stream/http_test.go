@@ -0,0 +1,199 @@
+package stream
+
+import (
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recorder is an HTTP handler that records the requests it receives, and
+// responds with the next status in its list.
+type recorder struct {
+	lock     sync.Mutex
+	statuses []int
+	reqs     []*http.Request
+	bodies   []string
+}
+
+func (rec *recorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rec.lock.Lock()
+	defer rec.lock.Unlock()
+	body, _ := ioutil.ReadAll(r.Body)
+	rec.reqs = append(rec.reqs, r)
+	rec.bodies = append(rec.bodies, string(body))
+
+	status := http.StatusOK
+	if len(rec.statuses) > 0 {
+		status = rec.statuses[0]
+		rec.statuses = rec.statuses[1:]
+	}
+	w.WriteHeader(status)
+	w.Write([]byte("handled"))
+}
+
+func TestHTTPAction(t *testing.T) {
+	rec := &recorder{}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	s, err := NewStream(".*", "", " ", "", []string{}, Options{
+		HTTP: &HTTPOptions{
+			Method: "put",
+			URL:    srv.URL + "/hosts/#{1}?line=#{0}",
+			Headers: map[string]string{
+				"Content-Type": "application/json",
+				"X-Host":       "#{1}",
+			},
+			Body: `{"host":"#{1}","msg":"#{2}"}`,
+		},
+	})
+	if err != nil {
+		t.Fatalf("got error creating stream: %v", err)
+	}
+
+	if err := s.ExecStreamComm(`web01 "quoted"&more`); err != nil {
+		t.Fatalf("got error executing action: %v", err)
+	}
+
+	if len(rec.reqs) != 1 {
+		t.Fatalf("expected 1 request, got %v", len(rec.reqs))
+	}
+	req := rec.reqs[0]
+	if req.Method != http.MethodPut {
+		t.Errorf("expected method PUT, got %v", req.Method)
+	}
+	if req.URL.Path != "/hosts/web01" || req.URL.Query().Get("line") != `web01 "quoted"&more` {
+		t.Errorf("expected fields escaped in the url, got %v", req.URL)
+	}
+	if req.Header.Get("X-Host") != "web01" {
+		t.Errorf("expected header X-Host: web01, got %v", req.Header.Get("X-Host"))
+	}
+	if exp := `{"host":"web01","msg":"\"quoted\"&more"}`; rec.bodies[0] != exp {
+		t.Errorf("expected body %v, got %v", exp, rec.bodies[0])
+	}
+}
+
+func TestHTTPActionStatus(t *testing.T) {
+	sleep = func(time.Duration) {}
+	defer func() {
+		sleep = time.Sleep
+	}()
+
+	testTable := []struct {
+		statuses []int
+		success  []int
+		requests int
+		status   int
+	}{
+		{
+			statuses: []int{http.StatusServiceUnavailable, http.StatusNoContent},
+			requests: 2,
+		},
+		{
+			statuses: []int{http.StatusTooManyRequests, http.StatusBadGateway, http.StatusInternalServerError},
+			requests: 3,
+			status:   http.StatusInternalServerError,
+		},
+		{
+			statuses: []int{http.StatusNotFound},
+			requests: 1,
+			status:   http.StatusNotFound,
+		},
+		{
+			statuses: []int{http.StatusNotFound},
+			success:  []int{http.StatusNotFound},
+			requests: 1,
+		},
+		{
+			statuses: []int{http.StatusOK},
+			success:  []int{http.StatusAccepted},
+			requests: 1,
+			status:   http.StatusOK,
+		},
+	}
+
+	for _, test := range testTable {
+		rec := &recorder{statuses: test.statuses}
+		srv := httptest.NewServer(rec)
+
+		s, err := NewStream(".*", "", " ", "", []string{}, Options{
+			Retry: Retry{Attempts: 3},
+			HTTP:  &HTTPOptions{URL: srv.URL, SuccessCodes: test.success},
+		})
+		if err != nil {
+			t.Fatalf("got error creating stream: %v", err)
+		}
+
+		err = s.ExecStreamComm("line")
+		srv.Close()
+
+		if len(rec.reqs) != test.requests {
+			t.Errorf("expected %v requests, got %v", test.requests, len(rec.reqs))
+		}
+		var httpErr *HTTPError
+		if test.status == 0 && err != nil {
+			t.Errorf("error returned, expected nil, got %v", err)
+		}
+		if test.status != 0 && (!errors.As(err, &httpErr) || httpErr.StatusCode != test.status) {
+			t.Errorf("expected an HTTPError with status %v, got %v", test.status, err)
+		}
+		if httpErr != nil && httpErr.Body != "handled" {
+			t.Errorf("expected the response body in the error, got %v", httpErr.Body)
+		}
+	}
+}
+
+func TestHTTPActionTLS(t *testing.T) {
+	rec := &recorder{}
+	srv := httptest.NewUnstartedServer(rec)
+	srv.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	srv.StartTLS()
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "streammon")
+	if err != nil {
+		t.Fatalf("got error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	caFile := filepath.Join(dir, "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := ioutil.WriteFile(caFile, ca, 0644); err != nil {
+		t.Fatalf("got error writing ca file: %v", err)
+	}
+
+	testTable := []struct {
+		opts HTTPOptions
+		err  bool
+	}{
+		{opts: HTTPOptions{URL: srv.URL}, err: true},
+		{opts: HTTPOptions{URL: srv.URL, InsecureSkipVerify: true}},
+		{opts: HTTPOptions{URL: srv.URL, CAFile: caFile}},
+	}
+
+	for _, test := range testTable {
+		opts := test.opts
+		s, err := NewStream(".*", "", " ", "", []string{}, Options{HTTP: &opts})
+		if err != nil {
+			t.Fatalf("got error creating stream: %v", err)
+		}
+		err = s.ExecStreamComm("line")
+		if test.err && err == nil {
+			t.Errorf("errors not returned for %+v, expected a certificate error", test.opts)
+		}
+		if !test.err && err != nil {
+			t.Errorf("error returned for %+v, expected nil, got %v", test.opts, err)
+		}
+	}
+
+	if _, err := NewStream(".*", "", " ", "", []string{}, Options{HTTP: &HTTPOptions{URL: srv.URL, CAFile: filepath.Join(dir, "missing")}}); err == nil {
+		t.Errorf("errors not returned for a missing ca file")
+	}
+}
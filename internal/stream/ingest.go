@@ -0,0 +1,208 @@
+package stream
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+var (
+	// DefaultIngestMax is the largest request body in bytes an HTTP ingest
+	// source accepts when it doesn't set its own limit.
+	DefaultIngestMax int64 = 1024 * 1024
+	// IngestHeaderTimeout is how long an HTTP ingest client has to send a
+	// request's headers, and IngestReadTimeout the whole request.
+	IngestHeaderTimeout = 10 * time.Second
+	IngestReadTimeout   = time.Minute
+)
+
+// IngestOptions configures a Stream reading lines POSTed to it over HTTP.
+type IngestOptions struct {
+	// Token, when it's set, has to be sent as a bearer token in the
+	// Authorization header.
+	Token string
+	// MaxBytes is the largest request body accepted, DefaultIngestMax when
+	// zero.
+	MaxBytes int64
+}
+
+var (
+	// ingestServers are the HTTP servers listening for ingest sources by
+	// their addresses, shared by the Streams with a path on each.
+	ingestServers = map[string]*ingestServer{}
+	ingestLock    sync.Mutex
+)
+
+// ingestServer routes the requests to an address to the Stream for each
+// path.
+type ingestServer struct {
+	mux   *http.ServeMux
+	paths map[string]bool
+}
+
+// ingestAddr returns the address and path of a Stream reading lines POSTed
+// to it, ie. its file is an http:// URL.
+func ingestAddr(file string) (string, string, bool) {
+	if !strings.HasPrefix(file, "http://") {
+		return "", "", false
+	}
+	addr := strings.TrimPrefix(file, "http://")
+	path := "/"
+	if i := strings.Index(addr, "/"); i >= 0 {
+		addr, path = addr[:i], addr[i:]
+	}
+	return addr, path, true
+}
+
+// checkIngestPath returns an error for a path an ingest source can't be
+// served on: one that would be read as a pattern by the server's mux, or
+// that a request's URL can't have as its path.
+func checkIngestPath(path string) error {
+	if i := strings.IndexFunc(path, func(r rune) bool {
+		return unicode.IsSpace(r) || strings.ContainsRune("{}?#", r)
+	}); i >= 0 {
+		return fmt.Errorf("the path of an http:// file can't have %q in it", path[i:i+1])
+	}
+	return nil
+}
+
+// listenIngest accepts lines POSTed to path on addr, publishing each of
+// them. Streams listening on the same address share its server. It returns
+// once the Stream's path is being served.
+func (s *Stream) listenIngest(addr, path string, swr Publisher) error {
+	if err := checkIngestPath(path); err != nil {
+		return err
+	}
+	ingestLock.Lock()
+	defer ingestLock.Unlock()
+
+	srv := ingestServers[addr]
+	if srv == nil {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			return err
+		}
+		srv = &ingestServer{mux: http.NewServeMux(), paths: map[string]bool{}}
+		ingestServers[addr] = srv
+		hs := &http.Server{
+			Handler:           srv.mux,
+			ReadHeaderTimeout: IngestHeaderTimeout,
+			ReadTimeout:       IngestReadTimeout,
+		}
+		go func() {
+			err := hs.Serve(ln)
+			fmt.Fprintf(os.Stderr, "error serving %s: %s\n", addr, err)
+		}()
+	}
+	if srv.paths[path] {
+		return errors.New("another stream is already listening on " + addr + path)
+	}
+	srv.paths[path] = true
+	srv.mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		s.ingest(w, r, swr)
+	})
+	return nil
+}
+
+// ingest handles a request POSTing lines to the Stream. The body is either
+// newline delimited text, or a JSON array of lines when its content type is
+// application/json, whose strings are read as lines the same way as the
+// text. Each line has a named field, client, of the address it was sent
+// from.
+func (s *Stream) ingest(w http.ResponseWriter, r *http.Request, swr Publisher) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "lines have to be POSTed", http.StatusMethodNotAllowed)
+		return
+	}
+	if token := s.ingestOpts.Token; token != "" {
+		auth := r.Header.Get("Authorization")
+		if subtle.ConstantTimeCompare([]byte(auth), []byte("Bearer "+token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "a valid bearer token is required", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	max := s.ingestOpts.MaxBytes
+	if max <= 0 {
+		max = DefaultIngestMax
+	}
+	// One byte past the limit is read to tell a body that's over it from
+	// one that's exactly as long.
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, max+1))
+	if err != nil {
+		http.Error(w, "error reading the request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if int64(len(body)) > max {
+		w.Header().Set("Connection", "close")
+		http.Error(w, fmt.Sprintf("the request is over %v bytes", max), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	fields := map[string]string{"client": r.RemoteAddr}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		fields["client"] = host
+	}
+	var rd io.Reader = s.decode(bytes.NewReader(body))
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		var lines []string
+		if err := json.Unmarshal(body, &lines); err != nil {
+			http.Error(w, "the body has to be a JSON array of strings", http.StatusBadRequest)
+			return
+		}
+		// The strings are already UTF-8, so they're only split into
+		// lines.
+		rd = strings.NewReader(strings.Join(lines, "\n"))
+	}
+	// The whole body is split before any of it's published, so a request
+	// that fails can be sent again without repeating lines.
+	var batch lineBatch
+	if err := s.splitLines(rd, s.file, fields, &batch); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, ev := range batch.events {
+		if swr.Err() != nil {
+			break
+		}
+		swr.PublishEvent(ev)
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// lineBatch is a Publisher that keeps the lines published to it, for the
+// lines of a request to be published together.
+type lineBatch struct {
+	events []Event
+}
+
+// Publish keeps a line.
+func (b *lineBatch) Publish(line string) {
+	b.PublishEvent(Event{Line: line})
+}
+
+// PublishEvent keeps a line with named fields.
+func (b *lineBatch) PublishEvent(ev Event) {
+	b.events = append(b.events, ev)
+}
+
+// Err returns nil, as a lineBatch keeps every line.
+func (b *lineBatch) Err() error {
+	return nil
+}
+
+// Close does nothing.
+func (b *lineBatch) Close() {}
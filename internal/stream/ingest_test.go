@@ -0,0 +1,125 @@
+package stream
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestIngestAddr(t *testing.T) {
+	testTable := []struct {
+		file string
+		addr string
+		path string
+		ok   bool
+	}{
+		{file: "http://:8080/ingest/ci", addr: ":8080", path: "/ingest/ci", ok: true},
+		{file: "http://127.0.0.1:9000", addr: "127.0.0.1:9000", path: "/", ok: true},
+		{file: "/var/log/http.log"},
+	}
+
+	for _, test := range testTable {
+		addr, path, ok := ingestAddr(test.file)
+		if addr != test.addr || path != test.path || ok != test.ok {
+			t.Errorf("address of %v was incorrect, expected %v %v %v, got %v %v %v",
+				test.file, test.addr, test.path, test.ok, addr, path, ok)
+		}
+	}
+
+	for _, file := range []string{"http://:8080/ingest/{ci}", "http://:8080/ingest ci", "http://:8080/ingest?ci"} {
+		if _, err := NewStream(".*", "echo", " ", file, []string{}, Options{}); err == nil {
+			t.Errorf("expected an error for the path of %v", file)
+		}
+	}
+}
+
+func TestListenIngest(t *testing.T) {
+	addr := freeAddr(t)
+	s, err := NewStream(".*", "echo", " ", "http://"+addr+"/ci", []string{}, Options{
+		Ingest:    IngestOptions{Token: "s3cret", MaxBytes: 64},
+		MaxLine:   20,
+		LongLines: LongLinesSkip,
+	})
+	if err != nil {
+		t.Fatalf("got error creating stream: %v", err)
+	}
+	s.lines = make(chan Event, 10)
+	if err := s.listenIngest(addr, "/ci", NewPublisher(s)); err != nil {
+		t.Fatalf("got error listening: %v", err)
+	}
+	if err := s.listenIngest(addr, "/ci", NewPublisher(s)); err == nil {
+		t.Errorf("expected an error for a path that's already listened on")
+	}
+
+	testTable := []struct {
+		method string
+		ctype  string
+		token  string
+		body   string
+		status int
+		lines  []string
+	}{
+		{method: "POST", token: "s3cret", body: "build 12 failed\r\nbuild 13 passed", status: 202, lines: []string{"build 12 failed", "build 13 passed"}},
+		{method: "POST", ctype: "application/json", token: "s3cret", body: `["deploy failed", "two\nlines"]`, status: 202, lines: []string{"deploy failed", "two", "lines"}},
+		{method: "POST", ctype: "application/json", token: "s3cret", body: `["` + strings.Repeat("x", 21) + `", "short"]`, status: 202, lines: []string{"short"}},
+		{method: "POST", ctype: "application/json", token: "s3cret", body: `{"line": "x"}`, status: 400},
+		{method: "POST", token: "wrong", body: "x", status: 401},
+		{method: "POST", body: "x", status: 401},
+		{method: "POST", token: "s3cret", body: strings.Repeat("x", 64), status: 202},
+		{method: "POST", token: "s3cret", body: strings.Repeat("x", 65), status: 413},
+		{method: "GET", token: "s3cret", status: 405},
+	}
+
+	for _, test := range testTable {
+		req, _ := http.NewRequest(test.method, "http://"+addr+"/ci", strings.NewReader(test.body))
+		if test.ctype != "" {
+			req.Header.Set("Content-Type", test.ctype)
+		}
+		if test.token != "" {
+			req.Header.Set("Authorization", "Bearer "+test.token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("got error sending request: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != test.status {
+			t.Errorf("status for %q was incorrect, expected %v, got %v", test.body, test.status, resp.StatusCode)
+		}
+
+		for _, line := range test.lines {
+			ev := <-s.lines
+			if ev.Line != line || ev.Fields["client"] != "127.0.0.1" {
+				t.Errorf("expected %q from 127.0.0.1, got %+v", line, ev)
+			}
+		}
+		if len(s.lines) != 0 {
+			t.Errorf("expected no more lines for %q, got %v", test.body, len(s.lines))
+		}
+	}
+}
+
+func TestIngestReadError(t *testing.T) {
+	// A request that can't be read isn't taken to be too large, and none
+	// of the lines read before the error are published.
+	s, err := NewStream(".*", "echo", " ", "http://127.0.0.1:0/ci", []string{}, Options{})
+	if err != nil {
+		t.Fatalf("got error creating stream: %v", err)
+	}
+	s.lines = make(chan Event, 10)
+	body := io.MultiReader(strings.NewReader("first\nsecond\n"), iotest.ErrReader(errors.New("connection reset")))
+	req := httptest.NewRequest("POST", "/ci", body)
+	w := httptest.NewRecorder()
+	s.ingest(w, req, NewPublisher(s))
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status was incorrect, expected %v, got %v", http.StatusBadRequest, w.Code)
+	}
+	if len(s.lines) != 0 {
+		t.Errorf("expected no lines, got %v", len(s.lines))
+	}
+}
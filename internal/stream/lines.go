@@ -0,0 +1,152 @@
+package stream
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// LongLines is the policy for a line longer than a Stream's max line length.
+type LongLines int
+
+const (
+	// LongLinesTruncate keeps the start of the line, up to the max length.
+	LongLinesTruncate LongLines = iota
+	// LongLinesSplit reads the line as several lines of the max length.
+	LongLinesSplit
+	// LongLinesSkip discards the line.
+	LongLinesSkip
+)
+
+// DefaultMaxLine is the max line length in bytes for a Stream that doesn't
+// set its own.
+var DefaultMaxLine = 1024 * 1024
+
+// ParseLongLines returns the LongLines for its config name, an empty string
+// is LongLinesTruncate.
+func ParseLongLines(str string) (LongLines, error) {
+	switch str {
+	case "", "truncate":
+		return LongLinesTruncate, nil
+	case "split":
+		return LongLinesSplit, nil
+	case "skip":
+		return LongLinesSkip, nil
+	}
+	return LongLinesTruncate, errors.New("long_lines must be one of truncate, split or skip")
+}
+
+// lineBuffer joins the chunks of a line read from a source, applying the
+// Stream's policy to lines over the max length so they're never held in
+// memory whole.
+type lineBuffer struct {
+	s       *Stream
+	source  string
+	partial []byte
+	// long is set once the line being read is over the max length.
+	long bool
+}
+
+// add appends a chunk of the line being read, end being whether it's the
+// end of the line (without its newline). It returns the lines to publish.
+func (b *lineBuffer) add(chunk []byte, end bool) []string {
+	var lines []string
+	if !b.long {
+		b.partial = append(b.partial, chunk...)
+	}
+	if len(b.partial) > b.s.maxLine {
+		switch b.s.longLines {
+		case LongLinesSplit:
+			for len(b.partial) > b.s.maxLine {
+				lines = append(lines, string(b.partial[:b.s.maxLine]))
+				b.partial = append([]byte{}, b.partial[b.s.maxLine:]...)
+			}
+		case LongLinesTruncate:
+			b.partial, b.long = b.partial[:b.s.maxLine], true
+		case LongLinesSkip:
+			b.partial, b.long = b.partial[:0], true
+		}
+	}
+	if !end {
+		return lines
+	}
+
+	if b.long {
+		b.s.countLong(b.source)
+	}
+	if !b.long || b.s.longLines == LongLinesTruncate {
+		lines = append(lines, strings.TrimSuffix(string(b.partial), "\r"))
+	}
+	b.reset()
+	return lines
+}
+
+// pending returns whether part of a line has been read.
+func (b *lineBuffer) pending() bool {
+	return len(b.partial) > 0 || b.long
+}
+
+// reset discards the line being read.
+func (b *lineBuffer) reset() {
+	b.partial, b.long = b.partial[:0], false
+}
+
+// countLong counts a line that was over the max length, warning about it.
+func (s *Stream) countLong(source string) {
+	n := atomic.AddInt64(&s.long, 1)
+	verb := map[LongLines]string{
+		LongLinesTruncate: "truncated",
+		LongLinesSkip:     "skipped",
+	}[s.longLines]
+	if verb != "" {
+		fmt.Fprintf(os.Stderr, "%s line over %v bytes from %s (%v so far)\n", verb, s.maxLine, source, n)
+	}
+}
+
+// LongLines returns the number of lines read that were over the max length.
+func (s *Stream) LongLines() int64 {
+	return atomic.LoadInt64(&s.long)
+}
+
+// scanLines publishes the lines read from rd, transcoded from the Stream's
+// encoding, until it ends, each with a copy of fields. It returns the error
+// if it isn't io.EOF.
+func (s *Stream) scanLines(rd io.Reader, source string, fields map[string]string, swr Publisher) error {
+	return s.splitLines(s.decode(rd), source, fields, swr)
+}
+
+// splitLines is scanLines for text that's already UTF-8.
+func (s *Stream) splitLines(rd io.Reader, source string, fields map[string]string, swr Publisher) error {
+	br := bufio.NewReader(rd)
+	buf := lineBuffer{s: s, source: source}
+	for {
+		chunk, err := br.ReadSlice('\n')
+		end := err == nil || err == io.EOF && (len(chunk) > 0 || buf.pending())
+		if err == nil {
+			chunk = chunk[:len(chunk)-1]
+		}
+		for _, line := range buf.add(chunk, end) {
+			if swr.Err() != nil {
+				continue
+			}
+			ev := Event{Line: line}
+			if fields != nil {
+				ev.Fields = map[string]string{}
+				for name, val := range fields {
+					ev.Fields[name] = val
+				}
+			}
+			swr.PublishEvent(ev)
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil && err != bufio.ErrBufferFull {
+			return err
+		}
+	}
+}
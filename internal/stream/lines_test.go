@@ -0,0 +1,87 @@
+package stream
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseLongLines(t *testing.T) {
+	testTable := []struct {
+		str  string
+		long LongLines
+		err  bool
+	}{
+		{str: "", long: LongLinesTruncate},
+		{str: "truncate", long: LongLinesTruncate},
+		{str: "split", long: LongLinesSplit},
+		{str: "skip", long: LongLinesSkip},
+		{str: "wrap", err: true},
+	}
+
+	for _, test := range testTable {
+		long, err := ParseLongLines(test.str)
+		if (err != nil) != test.err {
+			t.Errorf("error for %q was incorrect, expected %v, got %v", test.str, test.err, err)
+		}
+		if long != test.long {
+			t.Errorf("policy for %q was incorrect, expected %v, got %v", test.str, test.long, long)
+		}
+	}
+}
+
+func TestScanLines(t *testing.T) {
+	// The long line is bigger than the reader's buffer, so it's read in
+	// chunks.
+	long := strings.Repeat("x", 10000)
+	input := "short\r\n" + long + "\nabcdefghij\nlast"
+	testTable := []struct {
+		long  LongLines
+		lines []string
+		count int64
+	}{
+		{
+			long:  LongLinesTruncate,
+			lines: []string{"short", long[:8], "abcdefgh", "last"},
+			count: 2,
+		},
+		{
+			long:  LongLinesSkip,
+			lines: []string{"short", "last"},
+			count: 2,
+		},
+		{
+			long:  LongLinesSplit,
+			lines: append(append([]string{"short"}, strings.Split(strings.Repeat("xxxxxxxx ", 1250), " ")[:1250]...), "abcdefgh", "ij", "last"),
+			count: 0,
+		},
+	}
+
+	for _, test := range testTable {
+		s, err := NewStream(".*", "echo", " ", "", []string{}, Options{MaxLine: 8, LongLines: test.long})
+		if err != nil {
+			t.Fatalf("got error creating stream: %v", err)
+		}
+		s.lines = make(chan Event, len(test.lines)+1)
+		if err := s.scanLines(strings.NewReader(input), "stdin", nil, NewPublisher(s)); err != nil {
+			t.Fatalf("got error scanning lines: %v", err)
+		}
+
+		for _, line := range test.lines {
+			select {
+			case ev := <-s.lines:
+				if ev.Line != line {
+					t.Errorf("policy %v: expected %q, got %q", test.long, line, ev.Line)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("policy %v: timed out waiting for %q", test.long, line)
+			}
+		}
+		if len(s.lines) != 0 {
+			t.Errorf("policy %v: expected no more lines, got %v", test.long, len(s.lines))
+		}
+		if s.LongLines() != test.count {
+			t.Errorf("policy %v: expected %v long lines, got %v", test.long, test.count, s.LongLines())
+		}
+	}
+}
@@ -0,0 +1,270 @@
+package stream
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxSyslogMsg is the largest syslog message a listener accepts.
+const maxSyslogMsg = 64 * 1024
+
+// severityNames are the syslog severity names by their codes.
+var severityNames = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+// syslogAddr returns the network and address of a Stream reading from a
+// syslog listener, ie. its file is a udp:// or tcp:// address.
+func syslogAddr(file string) (string, string, bool) {
+	for _, network := range []string{"udp", "tcp"} {
+		if strings.HasPrefix(file, network+"://") {
+			return network, strings.TrimPrefix(file, network+"://"), true
+		}
+	}
+	return "", "", false
+}
+
+// listenSyslog receives syslog messages on addr and publishes each of them
+// as an Event. It returns once the listener is set up, and the messages are
+// published until the listener fails.
+func (s *Stream) listenSyslog(network, addr string, swr Publisher) error {
+	if network == "udp" {
+		conn, err := net.ListenPacket(network, addr)
+		if err != nil {
+			return err
+		}
+		go func() {
+			buf := make([]byte, maxSyslogMsg)
+			for {
+				n, from, err := conn.ReadFrom(buf)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "error reading %s: %s\n", s.file, err)
+					break
+				}
+				swr.PublishEvent(parseSyslog(string(buf[:n]), hostOf(from)))
+			}
+			conn.Close()
+			swr.Close()
+		}()
+		return nil
+	}
+
+	ln, err := net.Listen(network, addr)
+	if err != nil {
+		return err
+	}
+	go func() {
+		var wg sync.WaitGroup
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error reading %s: %s\n", s.file, err)
+				break
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				readSyslogConn(conn, swr)
+			}()
+		}
+		ln.Close()
+		// Let the clients still connected finish before closing.
+		wg.Wait()
+		swr.Close()
+	}()
+	return nil
+}
+
+// readSyslogConn publishes the messages received on a TCP connection until
+// the client hangs up.
+func readSyslogConn(conn net.Conn, swr Publisher) {
+	defer conn.Close()
+	from := hostOf(conn.RemoteAddr())
+	rd := bufio.NewReader(conn)
+	for {
+		msg, err := readSyslogFrame(rd)
+		if msg != "" {
+			swr.PublishEvent(parseSyslog(msg, from))
+		}
+		if err == io.EOF {
+			return
+		} else if err != nil {
+			fmt.Fprintf(os.Stderr, "error reading syslog from %s: %s\n", from, err)
+			return
+		}
+	}
+}
+
+// readSyslogFrame reads a message from a TCP syslog stream. Messages are
+// either octet counted, starting with their length, or end with a newline.
+func readSyslogFrame(rd *bufio.Reader) (string, error) {
+	b, err := rd.Peek(1)
+	if err != nil {
+		return "", err
+	}
+	if b[0] < '0' || b[0] > '9' {
+		msg, err := rd.ReadString('\n')
+		return strings.TrimRight(msg, "\r\n"), err
+	}
+
+	count, err := rd.ReadString(' ')
+	if err != nil {
+		return "", err
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(count, " "))
+	if err != nil || n <= 0 || n > maxSyslogMsg {
+		return "", errors.New("invalid syslog message length " + strings.TrimSpace(count))
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(rd, buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+// hostOf returns the host of a network address.
+func hostOf(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// parseSyslog parses an RFC 5424 or RFC 3164 message. The Event's line is
+// the message text, and its named fields are facility, severity, timestamp,
+// hostname, app_name, procid, msgid and message. The hostname is the sender
+// when the message doesn't have one, and a message that doesn't parse is
+// kept whole as the line.
+func parseSyslog(msg, from string) Event {
+	msg = strings.TrimRight(msg, "\r\n\x00")
+	fields := map[string]string{"hostname": from}
+	ev := Event{Line: msg, Fields: fields}
+
+	end := strings.IndexByte(msg, '>')
+	if !strings.HasPrefix(msg, "<") || end < 2 || end > 4 {
+		fields["message"] = msg
+		return ev
+	}
+	// The priority is only ever digits, which Atoi doesn't check.
+	digits := msg[1:end]
+	if strings.Trim(digits, "0123456789") != "" {
+		fields["message"] = msg
+		return ev
+	}
+	pri, err := strconv.Atoi(digits)
+	if err != nil || pri > 191 {
+		fields["message"] = msg
+		return ev
+	}
+	fields["facility"] = facilityName(pri / 8)
+	fields["severity"] = severityNames[pri%8]
+
+	rest := msg[end+1:]
+	if strings.HasPrefix(rest, "1 ") {
+		rest = parseRFC5424(rest[2:], fields)
+	} else {
+		rest = parseRFC3164(rest, fields)
+	}
+	ev.Line = strings.TrimPrefix(rest, "\xef\xbb\xbf")
+	fields["message"] = ev.Line
+	return ev
+}
+
+// parseRFC5424 sets the fields from the header and structured data of an
+// RFC 5424 message after its version, returning the message text.
+func parseRFC5424(rest string, fields map[string]string) string {
+	names := []string{"timestamp", "hostname", "app_name", "procid", "msgid"}
+	for _, name := range names {
+		val := rest
+		if i := strings.IndexByte(rest, ' '); i != -1 {
+			val, rest = rest[:i], rest[i+1:]
+		} else {
+			rest = ""
+		}
+		if val != "-" {
+			fields[name] = val
+		}
+	}
+
+	// The structured data is either nil or a run of bracketed elements,
+	// whose values can contain escaped quotes and brackets.
+	if strings.HasPrefix(rest, "-") {
+		return strings.TrimPrefix(rest[1:], " ")
+	}
+	i := 0
+	for i < len(rest) && rest[i] == '[' {
+		quoted := false
+		for i++; i < len(rest); i++ {
+			if rest[i] == '\\' {
+				i++
+			} else if rest[i] == '"' {
+				quoted = !quoted
+			} else if rest[i] == ']' && !quoted {
+				i++
+				break
+			}
+		}
+	}
+	if i > len(rest) {
+		i = len(rest)
+	}
+	if i > 0 {
+		fields["structured_data"] = rest[:i]
+	}
+	return strings.TrimPrefix(rest[i:], " ")
+}
+
+// parseRFC3164 sets the fields from the timestamp, hostname and tag of an
+// RFC 3164 message after its priority, returning the message text. Each
+// part is optional, as plenty of senders leave them out.
+func parseRFC3164(rest string, fields map[string]string) string {
+	if len(rest) > len(time.Stamp) && rest[len(time.Stamp)] == ' ' {
+		if _, err := time.Parse(time.Stamp, rest[:len(time.Stamp)]); err == nil {
+			fields["timestamp"] = rest[:len(time.Stamp)]
+			rest = rest[len(time.Stamp)+1:]
+			if i := strings.IndexByte(rest, ' '); i != -1 {
+				fields["hostname"], rest = rest[:i], rest[i+1:]
+			}
+		}
+	}
+
+	// The tag is the app name, with an optional [pid], ending with a colon.
+	i := strings.IndexAny(rest, "[: ")
+	if i <= 0 || i > 48 {
+		return rest
+	}
+	tag, after, pid := rest[:i], rest[i:], ""
+	if after[0] == '[' {
+		j := strings.IndexByte(after, ']')
+		if j == -1 {
+			return rest
+		}
+		pid, after = after[1:j], after[j+1:]
+	}
+	if !strings.HasPrefix(after, ":") {
+		return rest
+	}
+	fields["app_name"] = tag
+	if pid != "" {
+		fields["procid"] = pid
+	}
+	return strings.TrimPrefix(after[1:], " ")
+}
+
+// facilityName returns the name of a syslog facility code, or the code
+// itself when it doesn't have one.
+func facilityName(code int) string {
+	for name, c := range facilities {
+		if c == code {
+			return name
+		}
+	}
+	return strconv.Itoa(code)
+}
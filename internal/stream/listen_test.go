@@ -0,0 +1,168 @@
+package stream
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSyslog(t *testing.T) {
+	testTable := []struct {
+		msg    string
+		line   string
+		fields map[string]string
+	}{
+		{
+			msg:  `<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 [exampleSDID@32473 iut="3" eventSource="App]\"x"] An application event`,
+			line: "An application event",
+			fields: map[string]string{
+				"facility":        "local4",
+				"severity":        "notice",
+				"timestamp":       "2003-10-11T22:14:15.003Z",
+				"hostname":        "mymachine.example.com",
+				"app_name":        "evntslog",
+				"msgid":           "ID47",
+				"structured_data": `[exampleSDID@32473 iut="3" eventSource="App]\"x"]`,
+				"message":         "An application event",
+			},
+		},
+		{
+			msg:  "<34>1 2003-10-11T22:14:15.003Z - su 1234 - - \xef\xbb\xbf'su root' failed\n",
+			line: "'su root' failed",
+			fields: map[string]string{
+				"facility":  "auth",
+				"severity":  "crit",
+				"timestamp": "2003-10-11T22:14:15.003Z",
+				"hostname":  "10.0.0.9",
+				"app_name":  "su",
+				"procid":    "1234",
+				"message":   "'su root' failed",
+			},
+		},
+		{
+			msg:  "<38>Oct  9 22:33:20 fw01 sshd[4721]: Failed password for root",
+			line: "Failed password for root",
+			fields: map[string]string{
+				"facility":  "auth",
+				"severity":  "info",
+				"timestamp": "Oct  9 22:33:20",
+				"hostname":  "fw01",
+				"app_name":  "sshd",
+				"procid":    "4721",
+				"message":   "Failed password for root",
+			},
+		},
+		{
+			msg:  "<13>link down on port 3",
+			line: "link down on port 3",
+			fields: map[string]string{
+				"facility": "user",
+				"severity": "notice",
+				"hostname": "10.0.0.9",
+				"message":  "link down on port 3",
+			},
+		},
+		{
+			msg:  "<-1>x",
+			line: "<-1>x",
+			fields: map[string]string{
+				"hostname": "10.0.0.9",
+				"message":  "<-1>x",
+			},
+		},
+		{
+			msg:  "<+5>x",
+			line: "<+5>x",
+			fields: map[string]string{
+				"hostname": "10.0.0.9",
+				"message":  "<+5>x",
+			},
+		},
+		{
+			msg:  "no priority here",
+			line: "no priority here",
+			fields: map[string]string{
+				"hostname": "10.0.0.9",
+				"message":  "no priority here",
+			},
+		},
+	}
+
+	for _, test := range testTable {
+		ev := parseSyslog(test.msg, "10.0.0.9")
+		if ev.Line != test.line {
+			t.Errorf("line was incorrect, expected %q, got %q", test.line, ev.Line)
+		}
+		if len(ev.Fields) != len(test.fields) {
+			t.Errorf("fields were incorrect, expected %v, got %v", test.fields, ev.Fields)
+		}
+		for name, val := range test.fields {
+			if ev.Fields[name] != val {
+				t.Errorf("field %s was incorrect, expected %q, got %q", name, val, ev.Fields[name])
+			}
+		}
+	}
+}
+
+func TestReadSyslogFrame(t *testing.T) {
+	rd := bufio.NewReader(strings.NewReader("11 <13>one\ntwo<13>two\r\n9 three"))
+	for _, exp := range []string{"<13>one\ntwo", "<13>two"} {
+		msg, err := readSyslogFrame(rd)
+		if err != nil {
+			t.Fatalf("got error reading frame: %v", err)
+		}
+		if msg != exp {
+			t.Errorf("frame was incorrect, expected %q, got %q", exp, msg)
+		}
+	}
+	if _, err := readSyslogFrame(rd); err == nil {
+		t.Errorf("expected an error for a truncated frame")
+	}
+}
+
+// freeAddr returns a local address with a port that's free to listen on.
+func freeAddr(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("got error listening: %v", err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+func TestListenSyslog(t *testing.T) {
+	for _, network := range []string{"udp", "tcp"} {
+		addr := freeAddr(t)
+		s, err := NewStream(".*", "echo", " ", network+"://"+addr, []string{}, Options{})
+		if err != nil {
+			t.Fatalf("got error creating stream: %v", err)
+		}
+		if err := s.listenSyslog(network, addr, NewPublisher(s)); err != nil {
+			t.Fatalf("got error listening on %v: %v", network, err)
+		}
+
+		conn, err := net.Dial(network, addr)
+		if err != nil {
+			t.Fatalf("got error dialing %v: %v", network, err)
+		}
+		msg := "<11>1 - router1 bgpd - - - peer 10.0.0.2 down"
+		if network == "tcp" {
+			msg = fmt.Sprintf("%v %s", len(msg), msg)
+		}
+		conn.Write([]byte(msg))
+		conn.Close()
+
+		select {
+		case ev := <-s.lines:
+			if ev.Line != "peer 10.0.0.2 down" || ev.Fields["hostname"] != "router1" ||
+				ev.Fields["app_name"] != "bgpd" || ev.Fields["severity"] != "err" {
+				t.Errorf("event over %v was incorrect, got %+v", network, ev)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("timed out waiting for a message over %v", network)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package stream
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// Overflow is the policy for a Pool when its queue is full.
+type Overflow int
+
+const (
+	// OverflowBlock waits for space in the queue, which stops the stream
+	// being read until a worker is free.
+	OverflowBlock Overflow = iota
+	// OverflowDropNewest discards the job being submitted.
+	OverflowDropNewest
+	// OverflowDropOldest discards the oldest job in the queue to make
+	// room for the job being submitted.
+	OverflowDropOldest
+)
+
+var (
+	// DefaultWorkers is the number of workers for a Stream that doesn't
+	// set its own.
+	DefaultWorkers = 1
+	// DefaultQueue is the queue size for a Stream that doesn't set its
+	// own.
+	DefaultQueue = 64
+
+	// globalJobs limits the number of jobs running at once across all of
+	// the Pools, nil when there's no limit.
+	globalJobs chan struct{}
+)
+
+// SetMaxJobs limits the number of jobs running at once across every Stream.
+// It must be called before any Streams are created, n <= 0 removes the limit.
+func SetMaxJobs(n int) {
+	if n <= 0 {
+		globalJobs = nil
+		return
+	}
+	globalJobs = make(chan struct{}, n)
+}
+
+// ParseOverflow returns the Overflow for its config name, an empty string
+// is OverflowBlock.
+func ParseOverflow(str string) (Overflow, error) {
+	switch str {
+	case "", "block":
+		return OverflowBlock, nil
+	case "drop-newest":
+		return OverflowDropNewest, nil
+	case "drop-oldest":
+		return OverflowDropOldest, nil
+	}
+	return OverflowBlock, errors.New("overflow must be one of block, drop-newest or drop-oldest")
+}
+
+// Pool runs jobs on a fixed number of workers, holding jobs in a bounded
+// queue while the workers are busy.
+type Pool struct {
+	queues  []chan func()
+	policy  Overflow
+	dropped int64
+	wg      sync.WaitGroup
+}
+
+// NewPool starts the workers for a Pool. When ordered is true, each worker
+// has its own queue and jobs with the same key always go to the same worker,
+// so they run in the order they were submitted. Otherwise the workers share
+// one queue.
+func NewPool(workers, queue int, policy Overflow, ordered bool) *Pool {
+	if workers < 1 {
+		workers = 1
+	}
+	p := Pool{policy: policy}
+
+	if ordered {
+		size := queue / workers
+		if size < 1 && queue > 0 {
+			size = 1
+		}
+		for i := 0; i < workers; i++ {
+			p.queues = append(p.queues, make(chan func(), size))
+		}
+	} else {
+		p.queues = []chan func(){make(chan func(), queue)}
+	}
+
+	for i := 0; i < workers; i++ {
+		p.wg.Add(1)
+		go p.work(p.queues[i%len(p.queues)])
+	}
+	return &p
+}
+
+// work runs the jobs from queue until it's closed.
+func (p *Pool) work(queue chan func()) {
+	defer p.wg.Done()
+	for job := range queue {
+		if sem := globalJobs; sem != nil {
+			sem <- struct{}{}
+			job()
+			<-sem
+		} else {
+			job()
+		}
+	}
+}
+
+// Submit queues job to be run by the Pool, following the Pool's Overflow
+// policy when the queue is full. The key picks the queue for an ordered Pool.
+func (p *Pool) Submit(key string, job func()) {
+	queue := p.queues[0]
+	if len(p.queues) > 1 {
+		h := fnv.New32a()
+		h.Write([]byte(key))
+		queue = p.queues[h.Sum32()%uint32(len(p.queues))]
+	}
+
+	switch p.policy {
+	case OverflowDropNewest:
+		select {
+		case queue <- job:
+		default:
+			p.drop()
+		}
+	case OverflowDropOldest:
+		for {
+			select {
+			case queue <- job:
+				return
+			default:
+			}
+			select {
+			case <-queue:
+				p.drop()
+			default:
+				// Nothing queued to drop, wait for a worker.
+				queue <- job
+				return
+			}
+		}
+	default:
+		queue <- job
+	}
+}
+
+// drop counts a discarded job.
+func (p *Pool) drop() {
+	n := atomic.AddInt64(&p.dropped, 1)
+	if LogDebug {
+		fmt.Printf("queue full, dropped job (%v dropped)\n", n)
+	}
+}
+
+// Dropped returns the number of jobs discarded because the queue was full.
+func (p *Pool) Dropped() int64 {
+	return atomic.LoadInt64(&p.dropped)
+}
+
+// Close stops accepting jobs and waits for the queued jobs to finish.
+func (p *Pool) Close() {
+	for _, queue := range p.queues {
+		close(queue)
+	}
+	p.wg.Wait()
+}
+
+// Dispatch submits job to the Stream's Pool for a line that matched, using
+// the Stream's order key to keep the jobs for the same key in order.
+func (s *Stream) Dispatch(ev Event, job func()) {
+	key := ""
+	if s.orderKey != "" {
+		key = renderTokens(s.orderKey, lineFields(ev.Line, s.delim), ev.names(), nil)
+	}
+	s.pool.Submit(key, job)
+}
+
+// Wait waits for the Stream's dispatched jobs to finish, reports any that
+// were dropped, and closes its output log. No more jobs can be dispatched
+// afterwards.
+func (s *Stream) Wait() {
+	s.pool.Close()
+	if n := s.pool.Dropped(); n > 0 {
+		fmt.Fprintf(os.Stderr, "dropped %v matched lines from %s, the queue was full\n", n, s.source())
+	}
+	if s.outputLog != nil {
+		s.outputLog.close()
+	}
+}
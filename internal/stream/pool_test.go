@@ -0,0 +1,179 @@
+package stream
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseOverflow(t *testing.T) {
+	testTable := []struct {
+		str string
+		exp Overflow
+		err bool
+	}{
+		{str: "", exp: OverflowBlock},
+		{str: "block", exp: OverflowBlock},
+		{str: "drop-newest", exp: OverflowDropNewest},
+		{str: "drop-oldest", exp: OverflowDropOldest},
+		{str: "drop", err: true},
+	}
+
+	for _, test := range testTable {
+		resp, err := ParseOverflow(test.str)
+		if test.err && err == nil {
+			t.Errorf("errors not returned for %v, expected an error", test.str)
+		}
+		if !test.err && err != nil {
+			t.Errorf("error returned for %v, expected nil, got %v", test.str, err)
+		}
+		if resp != test.exp {
+			t.Errorf("response was incorrect for %v, expected %v, got %v", test.str, test.exp, resp)
+		}
+	}
+}
+
+func TestPoolOrdered(t *testing.T) {
+	p := NewPool(4, 64, OverflowBlock, true)
+
+	var lock sync.Mutex
+	got := map[string][]int{}
+	for i := 0; i < 50; i++ {
+		key := fmt.Sprintf("key%v", i%5)
+		i := i
+		p.Submit(key, func() {
+			lock.Lock()
+			defer lock.Unlock()
+			got[key] = append(got[key], i)
+		})
+	}
+	p.Close()
+
+	for key, seq := range got {
+		if len(seq) != 10 {
+			t.Errorf("expected 10 jobs for %v, got %v", key, len(seq))
+		}
+		for idx := 1; idx < len(seq); idx++ {
+			if seq[idx] < seq[idx-1] {
+				t.Errorf("jobs for %v ran out of order: %v", key, seq)
+				break
+			}
+		}
+	}
+}
+
+func TestPoolOverflow(t *testing.T) {
+	testTable := []struct {
+		policy  Overflow
+		dropped int64
+		ran     []int
+	}{
+		{policy: OverflowBlock, dropped: 0, ran: []int{0, 1, 2, 3, 4}},
+		{policy: OverflowDropNewest, dropped: 2, ran: []int{0, 1, 2}},
+		{policy: OverflowDropOldest, dropped: 2, ran: []int{0, 3, 4}},
+	}
+
+	for _, test := range testTable {
+		p := NewPool(1, 2, test.policy, false)
+		release := make(chan struct{})
+		started := make(chan struct{})
+
+		var lock sync.Mutex
+		ran := []int{}
+		for i := 0; i < 5; i++ {
+			i := i
+			p.Submit("", func() {
+				if i == 0 {
+					close(started)
+					<-release
+				}
+				lock.Lock()
+				ran = append(ran, i)
+				lock.Unlock()
+			})
+			if i == 0 {
+				// Make sure the worker is busy before filling the queue.
+				<-started
+			}
+			if test.policy == OverflowBlock && i == 2 {
+				close(release)
+			}
+		}
+		if test.policy != OverflowBlock {
+			close(release)
+		}
+		p.Close()
+
+		if p.Dropped() != test.dropped {
+			t.Errorf("expected %v dropped for policy %v, got %v", test.dropped, test.policy, p.Dropped())
+		}
+		if fmt.Sprint(ran) != fmt.Sprint(test.ran) {
+			t.Errorf("expected jobs %v to run for policy %v, got %v", test.ran, test.policy, ran)
+		}
+	}
+}
+
+func TestMaxJobs(t *testing.T) {
+	SetMaxJobs(2)
+	defer SetMaxJobs(0)
+
+	pools := []*Pool{
+		NewPool(3, 10, OverflowBlock, false),
+		NewPool(3, 10, OverflowBlock, false),
+	}
+	var running, most int32
+	for i := 0; i < 12; i++ {
+		pools[i%2].Submit("", func() {
+			n := atomic.AddInt32(&running, 1)
+			for {
+				m := atomic.LoadInt32(&most)
+				if n <= m || atomic.CompareAndSwapInt32(&most, m, n) {
+					break
+				}
+			}
+			time.Sleep(2 * time.Millisecond)
+			atomic.AddInt32(&running, -1)
+		})
+	}
+	for _, p := range pools {
+		p.Close()
+	}
+
+	if most > 2 {
+		t.Errorf("expected at most 2 jobs at once, got %v", most)
+	}
+}
+
+func TestDispatch(t *testing.T) {
+	s, err := NewStream(".*", "echo", " ", "/dev/null", []string{}, Options{
+		Workers:  3,
+		OrderKey: "#{1}",
+	})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	var lock sync.Mutex
+	got := map[string][]string{}
+	for i := 0; i < 30; i++ {
+		line := fmt.Sprintf("host%v %v", i%3, i)
+		s.Dispatch(Event{Line: line}, func() {
+			lock.Lock()
+			defer lock.Unlock()
+			key := line[:5]
+			got[key] = append(got[key], line)
+		})
+	}
+	s.Wait()
+
+	for key, lines := range got {
+		for idx, line := range lines {
+			exp := fmt.Sprintf("%v %v", key, idx*3+int(key[4]-'0'))
+			if line != exp {
+				t.Errorf("lines for %v ran out of order, expected %v, got %v", key, exp, line)
+			}
+		}
+	}
+}
@@ -0,0 +1,294 @@
+package stream
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// DefaultRedisAddr is the server for a redis action that doesn't set
+	// its own.
+	DefaultRedisAddr = "localhost:6379"
+	// DefaultRedisPool is the most connections a redis action keeps open
+	// when it doesn't set its own.
+	DefaultRedisPool = 4
+	// DefaultRedisTimeout is the timeout for connecting to the server and
+	// for each command when a redis action doesn't set its own.
+	DefaultRedisTimeout = 5 * time.Second
+)
+
+// RedisOptions configures a command sent to a Redis server for each matched
+// line. The key, value and fields can contain field tokens.
+type RedisOptions struct {
+	Addr     string
+	Password string
+	DB       int
+
+	// Command is one of PUBLISH, LPUSH, XADD or INCR.
+	Command string
+	// Key is the channel for PUBLISH, or the key of the list, stream or
+	// counter.
+	Key string
+	// Value is the message or list element, "#{0}" when empty.
+	Value string
+	// Fields are the field names and values of an XADD entry, the whole
+	// line as "line" when empty.
+	Fields map[string]string
+
+	PoolSize int
+	Timeout  time.Duration
+}
+
+// RedisError is an error reply from the Redis server.
+type RedisError string
+
+// Error returns the server's error message.
+func (e RedisError) Error() string {
+	return string(e)
+}
+
+// redisAction sends a command to a Redis server for each matched line.
+type redisAction struct {
+	command string
+	key     template
+	value   template
+	names   []string
+	fields  map[string]template
+	pool    *redisPool
+}
+
+// newRedisAction creates the action from its options, with the fields split
+// on delim.
+func newRedisAction(opts RedisOptions, delim string) (*redisAction, error) {
+	a := redisAction{
+		command: strings.ToUpper(opts.Command),
+		key:     newTemplate(opts.Key, delim, nil),
+		fields:  map[string]template{},
+	}
+	switch a.command {
+	case "PUBLISH", "LPUSH", "XADD", "INCR":
+	default:
+		return nil, errors.New("a redis action's command must be one of PUBLISH, LPUSH, XADD or INCR")
+	}
+	if opts.Key == "" {
+		return nil, errors.New("a redis action needs a key")
+	}
+
+	if opts.Value == "" {
+		opts.Value = "#{0}"
+	}
+	a.value = newTemplate(opts.Value, delim, nil)
+	if len(opts.Fields) == 0 {
+		opts.Fields = map[string]string{"line": "#{0}"}
+	}
+	for name, val := range opts.Fields {
+		a.names = append(a.names, name)
+		a.fields[name] = newTemplate(val, delim, nil)
+	}
+	sort.Strings(a.names)
+
+	if opts.Addr == "" {
+		opts.Addr = DefaultRedisAddr
+	}
+	if opts.PoolSize <= 0 {
+		opts.PoolSize = DefaultRedisPool
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultRedisTimeout
+	}
+	a.pool = &redisPool{
+		opts: opts,
+		idle: make(chan *redisConn, opts.PoolSize),
+		sem:  make(chan struct{}, opts.PoolSize),
+	}
+	return &a, nil
+}
+
+// Run sends the command for a matched line.
+func (a *redisAction) Run(ev Event) error {
+	args := []string{a.command, a.key.render(ev)}
+	switch a.command {
+	case "PUBLISH", "LPUSH":
+		args = append(args, a.value.render(ev))
+	case "XADD":
+		args = append(args, "*")
+		for _, name := range a.names {
+			args = append(args, name, a.fields[name].render(ev))
+		}
+	}
+
+	if LogDebug {
+		fmt.Printf("calling redis %v\n", args)
+	}
+	reply, err := a.pool.do(args)
+	if err != nil {
+		return err
+	}
+	if LogDebug {
+		fmt.Printf("output: %v matched line: %s.\n", reply, ev.Line)
+	}
+	return nil
+}
+
+// redisPool holds open connections to a Redis server, dialing more as
+// they're needed up to the pool size.
+type redisPool struct {
+	opts RedisOptions
+	idle chan *redisConn
+	sem  chan struct{}
+}
+
+// do sends a command on a pooled connection. A connection that fails is
+// closed rather than returned to the pool. An idle connection the server
+// has closed is replaced before it's used, and when a stale connection
+// fails to write the command it's tried once more on a new one. Once the
+// command's been written it's never sent again, as the server may have run
+// it, so a failure reading the reply is returned.
+func (p *redisPool) do(args []string) (interface{}, error) {
+	p.sem <- struct{}{}
+	defer func() { <-p.sem }()
+
+	var conn *redisConn
+	select {
+	case conn = <-p.idle:
+	default:
+	}
+
+	if conn != nil && peerClosed(conn.Conn) {
+		conn.Close()
+		conn = nil
+	}
+
+	if conn != nil {
+		reply, err := conn.do(args, p.opts.Timeout)
+		if !conn.broken {
+			p.idle <- conn
+			return reply, err
+		}
+		conn.Close()
+		if !conn.unsent {
+			return nil, err
+		}
+	}
+
+	conn, err := p.dial()
+	if err != nil {
+		return nil, err
+	}
+	reply, err := conn.do(args, p.opts.Timeout)
+	if conn.broken {
+		conn.Close()
+	} else {
+		p.idle <- conn
+	}
+	return reply, err
+}
+
+// dial connects to the server, authenticating and selecting the database
+// if the options ask for it.
+func (p *redisPool) dial() (*redisConn, error) {
+	nc, err := net.DialTimeout("tcp", p.opts.Addr, p.opts.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	conn := &redisConn{Conn: nc, rd: bufio.NewReader(nc)}
+
+	if p.opts.Password != "" {
+		if _, err := conn.do([]string{"AUTH", p.opts.Password}, p.opts.Timeout); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if p.opts.DB != 0 {
+		if _, err := conn.do([]string{"SELECT", strconv.Itoa(p.opts.DB)}, p.opts.Timeout); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// redisConn is a connection to a Redis server speaking RESP.
+type redisConn struct {
+	net.Conn
+	rd *bufio.Reader
+	// broken is set when the connection can't be used again, and unsent
+	// when that's because the last command couldn't be written.
+	broken bool
+	unsent bool
+}
+
+// do writes a command and reads its reply. An error reply from the server
+// is returned as a RedisError, and leaves the connection usable.
+func (c *redisConn) do(args []string, timeout time.Duration) (interface{}, error) {
+	c.SetDeadline(time.Now().Add(timeout))
+
+	var sbuff strings.Builder
+	sbuff.WriteString(fmt.Sprintf("*%v\r\n", len(args)))
+	for _, arg := range args {
+		sbuff.WriteString(fmt.Sprintf("$%v\r\n%s\r\n", len(arg), arg))
+	}
+	if _, err := io.WriteString(c, sbuff.String()); err != nil {
+		c.broken, c.unsent = true, true
+		return nil, err
+	}
+
+	reply, err := c.read()
+	if _, ok := err.(RedisError); err != nil && !ok {
+		c.broken = true
+	}
+	return reply, err
+}
+
+// read parses a RESP reply.
+func (c *redisConn) read() (interface{}, error) {
+	line, err := c.rd.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || !strings.HasSuffix(line, "\r\n") {
+		return nil, fmt.Errorf("invalid redis reply %q", line)
+	}
+	kind, body := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return body, nil
+	case '-':
+		return nil, RedisError(body)
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.rd, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]interface{}, 0, n)
+		for i := 0; i < n; i++ {
+			item, err := c.read()
+			if _, ok := err.(RedisError); err != nil && !ok {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("invalid redis reply %q", line)
+}
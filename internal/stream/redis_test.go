@@ -0,0 +1,249 @@
+package stream
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// respServer is a tiny Redis server that records the commands it receives.
+// It replies +OK to everything except INCR, which gets a count, and a
+// command named FAIL, which gets an error.
+type respServer struct {
+	ln       net.Listener
+	lock     sync.Mutex
+	cmds     [][]string
+	conns    int
+	password string
+	// hangup closes each connection after its first command, noreply
+	// before replying to it.
+	hangup  bool
+	noreply bool
+}
+
+func newRESPServer(t *testing.T, password string, hangup bool) *respServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("got error listening: %v", err)
+	}
+	srv := &respServer{ln: ln, password: password, hangup: hangup}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			srv.lock.Lock()
+			srv.conns++
+			srv.lock.Unlock()
+			go srv.serve(conn)
+		}
+	}()
+	return srv
+}
+
+func (srv *respServer) serve(conn net.Conn) {
+	defer conn.Close()
+	rd := bufio.NewReader(conn)
+	authed := srv.password == ""
+	for {
+		cmd, err := readCommand(rd)
+		if err != nil {
+			return
+		}
+
+		srv.lock.Lock()
+		srv.cmds = append(srv.cmds, cmd)
+		n := len(srv.cmds)
+		noreply := srv.noreply
+		srv.lock.Unlock()
+		if noreply {
+			return
+		}
+
+		switch {
+		case cmd[0] == "AUTH" && cmd[1] == srv.password:
+			authed = true
+			io.WriteString(conn, "+OK\r\n")
+		case !authed:
+			io.WriteString(conn, "-NOAUTH Authentication required.\r\n")
+		case cmd[0] == "FAIL":
+			io.WriteString(conn, "-ERR failed\r\n")
+		case cmd[0] == "INCR":
+			io.WriteString(conn, fmt.Sprintf(":%v\r\n", n))
+		default:
+			io.WriteString(conn, "+OK\r\n")
+		}
+		if srv.hangup && cmd[0] != "AUTH" {
+			return
+		}
+	}
+}
+
+func (srv *respServer) commands() [][]string {
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+	return srv.cmds
+}
+
+func (srv *respServer) connections() int {
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+	return srv.conns
+}
+
+// readCommand parses a RESP array of bulk strings.
+func readCommand(rd *bufio.Reader) ([]string, error) {
+	line, err := rd.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+	cmd := []string{}
+	for i := 0; i < n; i++ {
+		line, err := rd.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(rd, buf); err != nil {
+			return nil, err
+		}
+		cmd = append(cmd, string(buf[:size]))
+	}
+	return cmd, nil
+}
+
+func TestRedisAction(t *testing.T) {
+	srv := newRESPServer(t, "secret", false)
+	defer srv.ln.Close()
+
+	line := "GET /index.html 10.0.0.1"
+	testTable := []struct {
+		opts RedisOptions
+		exp  []string
+	}{
+		{
+			opts: RedisOptions{Command: "publish", Key: "requests"},
+			exp:  []string{"PUBLISH", "requests", line},
+		},
+		{
+			opts: RedisOptions{Command: "LPUSH", Key: "hits:#{3}", Value: "#{2}"},
+			exp:  []string{"LPUSH", "hits:10.0.0.1", "/index.html"},
+		},
+		{
+			opts: RedisOptions{Command: "XADD", Key: "requests", Fields: map[string]string{"path": "#{2}", "ip": "#{3}"}},
+			exp:  []string{"XADD", "requests", "*", "ip", "10.0.0.1", "path", "/index.html"},
+		},
+		{
+			opts: RedisOptions{Command: "INCR", Key: "count:#{1}"},
+			exp:  []string{"INCR", "count:GET"},
+		},
+	}
+
+	for _, test := range testTable {
+		opts := test.opts
+		opts.Addr = srv.ln.Addr().String()
+		opts.Password = srv.password
+		s, err := NewStream(".*", "", " ", "", []string{}, Options{Redis: &opts})
+		if err != nil {
+			t.Fatalf("got error creating stream: %v", err)
+		}
+		if err := s.ExecStreamComm(line); err != nil {
+			t.Errorf("got error executing action: %v", err)
+		}
+
+		cmds := srv.commands()
+		got := cmds[len(cmds)-1]
+		if fmt.Sprintf("%q", got) != fmt.Sprintf("%q", test.exp) {
+			t.Errorf("expected command %q, got %q", test.exp, got)
+		}
+	}
+}
+
+func TestRedisActionErrors(t *testing.T) {
+	srv := newRESPServer(t, "", false)
+	defer srv.ln.Close()
+
+	if _, err := newRedisAction(RedisOptions{Command: "DEL", Key: "k"}, " "); err == nil {
+		t.Errorf("errors not returned for an unsupported command")
+	}
+
+	a, err := newRedisAction(RedisOptions{Addr: srv.ln.Addr().String(), Command: "PUBLISH", Key: "k"}, " ")
+	if err != nil {
+		t.Fatalf("got error creating action: %v", err)
+	}
+	a.command = "FAIL"
+	if err := a.Run(Event{Line: "line"}); err == nil || err.Error() != "ERR failed" {
+		t.Errorf("expected the server's error, got %v", err)
+	}
+
+	// An error reply leaves the connection in the pool.
+	a.command = "PUBLISH"
+	if err := a.Run(Event{Line: "line"}); err != nil {
+		t.Errorf("got error executing action: %v", err)
+	}
+	if srv.connections() != 1 {
+		t.Errorf("expected the connection to be reused, got %v connections", srv.connections())
+	}
+}
+
+func TestRedisActionReconnect(t *testing.T) {
+	srv := newRESPServer(t, "", true)
+	defer srv.ln.Close()
+
+	a, err := newRedisAction(RedisOptions{Addr: srv.ln.Addr().String(), Command: "PUBLISH", Key: "k", PoolSize: 1}, " ")
+	if err != nil {
+		t.Fatalf("got error creating action: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := a.Run(Event{Line: fmt.Sprintf("line %v", i)}); err != nil {
+			t.Errorf("got error executing action: %v", err)
+		}
+		// Give the server time to hang up.
+		time.Sleep(20 * time.Millisecond)
+	}
+	if n := len(srv.commands()); n != 3 {
+		t.Errorf("expected 3 commands, got %v", n)
+	}
+	if srv.connections() != 3 {
+		t.Errorf("expected a new connection for each command, got %v", srv.connections())
+	}
+
+	srv.ln.Close()
+	if err := a.Run(Event{Line: "line"}); err == nil {
+		t.Errorf("errors not returned with the server down")
+	}
+}
+
+func TestRedisActionNoReply(t *testing.T) {
+	srv := newRESPServer(t, "", false)
+	defer srv.ln.Close()
+
+	a, err := newRedisAction(RedisOptions{Addr: srv.ln.Addr().String(), Command: "INCR", Key: "k", PoolSize: 1}, " ")
+	if err != nil {
+		t.Fatalf("got error creating action: %v", err)
+	}
+	if err := a.Run(Event{Line: "line"}); err != nil {
+		t.Errorf("got error executing action: %v", err)
+	}
+
+	// The command was written on the pooled connection, so it isn't sent
+	// again on a new one when there's no reply.
+	srv.lock.Lock()
+	srv.noreply = true
+	srv.lock.Unlock()
+	if err := a.Run(Event{Line: "line"}); err == nil {
+		t.Errorf("errors not returned without a reply")
+	}
+	if n := len(srv.commands()); n != 2 {
+		t.Errorf("expected 2 commands, got %v", n)
+	}
+}
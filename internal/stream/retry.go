@@ -0,0 +1,123 @@
+package stream
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"time"
+)
+
+var (
+	// DefaultBackoff is the wait before the first retry when a Retry
+	// doesn't set its own.
+	DefaultBackoff = 100 * time.Millisecond
+	// DefaultMaxBackoff is the longest wait between retries when a Retry
+	// doesn't set its own.
+	DefaultMaxBackoff = 30 * time.Second
+
+	// sleep waits between retries, replaced in tests.
+	sleep = time.Sleep
+)
+
+// Retry is the policy for running a Stream's command again after it fails.
+// The zero value runs the command once.
+type Retry struct {
+	// Attempts is the most times the command is run, including the first.
+	Attempts int
+	// Backoff is the wait before the first retry, which doubles for each
+	// retry after it.
+	Backoff time.Duration
+	// MaxBackoff caps the wait between retries.
+	MaxBackoff time.Duration
+	// Jitter is the fraction of each wait, from 0 to 1, that's randomly
+	// taken off so failing commands don't retry in lock step.
+	Jitter float64
+	// ExitCodes are the exit statuses of a command worth retrying. When
+	// empty, every failure is retried.
+	ExitCodes []int
+}
+
+// Do calls fn until it succeeds, the error isn't retryable, or the attempts
+// run out, returning the last error.
+func (r Retry) Do(fn func() error) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = fn(); err == nil {
+			return nil
+		}
+		if attempt >= r.Attempts || !r.retryable(err) {
+			return err
+		}
+
+		wait := r.delay(attempt)
+		if LogDebug {
+			fmt.Printf("attempt %v failed: %s, retrying in %v\n", attempt, err, wait)
+		}
+		sleep(wait)
+	}
+}
+
+// retryable reports whether err is worth retrying under the policy. Only
+// server errors, rate limiting and failures to reach the server are retried
+// for an HTTP action, whatever the exit codes.
+func (r Retry) retryable(err error) bool {
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.StatusCode >= 500 || httpErr.StatusCode == http.StatusTooManyRequests
+	}
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) && unreachable(urlErr) {
+		return true
+	}
+	if len(r.ExitCodes) == 0 {
+		return true
+	}
+
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return false
+	}
+	for _, code := range r.ExitCodes {
+		if exitErr.ExitCode() == code {
+			return true
+		}
+	}
+	return false
+}
+
+// unreachable reports whether a request failed because the server couldn't
+// be reached or didn't answer in time, rather than because it was invalid.
+func unreachable(err *url.Error) bool {
+	var opErr *net.OpError
+	return err.Timeout() || errors.As(err.Err, &opErr) ||
+		errors.Is(err.Err, io.EOF) || errors.Is(err.Err, io.ErrUnexpectedEOF)
+}
+
+// delay returns the wait after the numbered attempt failed.
+func (r Retry) delay(attempt int) time.Duration {
+	backoff, max := r.Backoff, r.MaxBackoff
+	if backoff <= 0 {
+		backoff = DefaultBackoff
+	}
+	if max <= 0 {
+		max = DefaultMaxBackoff
+	}
+
+	wait := backoff
+	for i := 1; i < attempt && wait < max; i++ {
+		wait *= 2
+	}
+	if wait > max {
+		wait = max
+	}
+
+	if r.Jitter > 0 {
+		wait -= time.Duration(rand.Float64() * r.Jitter * float64(wait))
+	}
+	return wait
+}
@@ -0,0 +1,154 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRetryDo(t *testing.T) {
+	waits := []time.Duration{}
+	sleep = func(d time.Duration) {
+		waits = append(waits, d)
+	}
+	defer func() {
+		sleep = time.Sleep
+	}()
+
+	exit3 := exec.Command("sh", "-c", "exit 3").Run()
+	exit4 := exec.Command("sh", "-c", "exit 4").Run()
+	refused := &url.Error{Op: "Post", URL: "http://localhost:1", Err: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}}
+	timeout := &url.Error{Op: "Post", URL: "http://localhost:1", Err: context.DeadlineExceeded}
+	scheme := &url.Error{Op: "Post", URL: "localhost:1", Err: errors.New("unsupported protocol scheme")}
+
+	testTable := []struct {
+		r     Retry
+		errs  []error
+		calls int
+		err   error
+		waits []time.Duration
+	}{
+		{
+			r:     Retry{},
+			errs:  []error{errors.New("failed")},
+			calls: 1,
+			err:   errors.New("failed"),
+			waits: []time.Duration{},
+		},
+		{
+			r:     Retry{Attempts: 3, Backoff: time.Second},
+			errs:  []error{errors.New("failed"), nil},
+			calls: 2,
+			waits: []time.Duration{time.Second},
+		},
+		{
+			r:     Retry{Attempts: 4, Backoff: time.Second, MaxBackoff: 3 * time.Second},
+			errs:  []error{exit3, exit3, exit3, exit3},
+			calls: 4,
+			err:   exit3,
+			waits: []time.Duration{time.Second, 2 * time.Second, 3 * time.Second},
+		},
+		{
+			r:     Retry{Attempts: 4, Backoff: time.Second, ExitCodes: []int{3}},
+			errs:  []error{exit3, exit4},
+			calls: 2,
+			err:   exit4,
+			waits: []time.Duration{time.Second},
+		},
+		{
+			r:     Retry{Attempts: 4, ExitCodes: []int{3}},
+			errs:  []error{errors.New("not an exit status")},
+			calls: 1,
+			err:   errors.New("not an exit status"),
+			waits: []time.Duration{},
+		},
+		{
+			r:     Retry{Attempts: 4, Backoff: time.Second, ExitCodes: []int{3}},
+			errs:  []error{refused, timeout, nil},
+			calls: 3,
+			waits: []time.Duration{time.Second, 2 * time.Second},
+		},
+		{
+			r:     Retry{Attempts: 4, ExitCodes: []int{3}},
+			errs:  []error{scheme},
+			calls: 1,
+			err:   scheme,
+			waits: []time.Duration{},
+		},
+	}
+
+	for _, test := range testTable {
+		waits = []time.Duration{}
+		calls := 0
+		err := test.r.Do(func() error {
+			err := test.errs[calls]
+			calls++
+			return err
+		})
+
+		if calls != test.calls {
+			t.Errorf("expected %v calls, got %v", test.calls, calls)
+		}
+		if (err == nil) != (test.err == nil) || err != nil && err.Error() != test.err.Error() {
+			t.Errorf("unexpected error returned, expected %v, got %v", test.err, err)
+		}
+		if len(waits) != len(test.waits) {
+			t.Errorf("expected waits of %v, got %v", test.waits, waits)
+			continue
+		}
+		for idx := range waits {
+			if waits[idx] != test.waits[idx] {
+				t.Errorf("expected waits of %v, got %v", test.waits, waits)
+				break
+			}
+		}
+	}
+}
+
+func TestRetryJitter(t *testing.T) {
+	r := Retry{Backoff: time.Second, Jitter: 0.5}
+	for i := 0; i < 100; i++ {
+		wait := r.delay(2)
+		if wait < time.Second || wait > 2*time.Second {
+			t.Fatalf("expected a wait between 1s and 2s, got %v", wait)
+		}
+	}
+}
+
+func TestExecStreamCommRetry(t *testing.T) {
+	sleep = func(time.Duration) {}
+	defer func() {
+		sleep = time.Sleep
+	}()
+
+	dir, err := ioutil.TempDir("", "streammon")
+	if err != nil {
+		t.Fatalf("got error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	marker := filepath.Join(dir, "marker")
+
+	// The command fails the first time it runs, and succeeds after.
+	s, err := NewStream(
+		".*",
+		"sh",
+		" ",
+		"",
+		[]string{"-c", "test -e " + marker + " || { touch " + marker + "; exit 75; }"},
+		Options{Retry: Retry{Attempts: 2, ExitCodes: []int{75}}},
+	)
+	if err != nil {
+		t.Fatalf("got error creating stream: %v", err)
+	}
+
+	if err := s.ExecStreamComm("line"); err != nil {
+		t.Errorf("got error executing command: %v", err)
+	}
+}
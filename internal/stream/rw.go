@@ -2,14 +2,12 @@ package stream
 
 import (
 	"errors"
-	"fmt"
-	"os"
 	"sync"
+	"time"
 )
 
 var (
-	// numSubs tracks the number of subscribers. Will close when all are
-	// closed.
+	// numSubs tracks the number of subscribers.
 	numSubs = 0
 	lock    *sync.Mutex
 )
@@ -40,7 +38,7 @@ func getSubs() int {
 // Stream, allowing for communication between the interested parties.
 type RW struct {
 	stream   *Stream
-	streamer chan string
+	streamer chan Event
 	err      error
 }
 
@@ -66,7 +64,7 @@ func NewPublisher(s *Stream) Publisher {
 }
 
 // Subscribe returns a channel where text will be sent unless closed.
-func (srw *RW) Subscribe() chan string {
+func (srw *RW) Subscribe() chan Event {
 	go func() {
 		srw.stream.readLines()
 	}()
@@ -75,8 +73,16 @@ func (srw *RW) Subscribe() chan string {
 
 // Publish sends a string to the channel that Subscribers will recieve.
 func (srw *RW) Publish(line string) {
-	srw.streamer <- line
-	return
+	srw.PublishEvent(Event{Line: line})
+}
+
+// PublishEvent sends a line with named fields to the channel that
+// Subscribers will recieve.
+func (srw *RW) PublishEvent(ev Event) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	srw.streamer <- ev
 }
 
 // Err returns any errors associated with the RW.
@@ -89,9 +95,5 @@ func (srw *RW) Close() {
 	close(srw.streamer)
 	srw.err = errors.New("streamer closed for publishing")
 	takeSub()
-	if getSubs() == 0 {
-		fmt.Fprintln(os.Stdout, "No more files to watch, closing.")
-		os.Exit(0)
-	}
 	return
 }
@@ -15,6 +15,7 @@ func TestSubscriber(t *testing.T) {
 		":",
 		"/dev/null",
 		[]string{"foo", "bar"},
+		Options{},
 	)
 	if err != nil {
 		t.Errorf(err.Error())
@@ -39,10 +40,10 @@ func TestSubscriber(t *testing.T) {
 
 	go func(wg *sync.WaitGroup) {
 		defer wg.Done()
-		for line := range srw.Subscribe() {
-			match := s.Regexp.MatchString(line)
+		for ev := range srw.Subscribe() {
+			match := s.Regexp.MatchString(ev.Line)
 			if match {
-				if err := s.ExecStreamComm(line); err != nil {
+				if err := s.ExecEvent(ev); err != nil {
 					fmt.Fprintf(os.Stderr, "error exec command %s: \n", err.Error())
 				}
 			}
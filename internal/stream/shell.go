@@ -0,0 +1,134 @@
+package stream
+
+import (
+	"strconv"
+	"strings"
+)
+
+// prepShell builds the command line for a Stream in shell mode. The command
+// and arguments are joined with spaces, and any field tokens are replaced
+// with the shell quoted field text. Fields that don't exist in the line are
+// replaced with an empty string.
+func prepShell(ev Event, s *Stream) string {
+	tmpl := strings.Join(append([]string{s.cmd}, s.args...), " ")
+	spl := strings.Split(ev.Line, s.delim)
+	return renderShell(tmpl, func(field int) string {
+		val, _ := fieldValue(ev.Line, spl, field)
+		return val
+	}, ev.names())
+}
+
+// renderShell replaces the field tokens in tmpl with the values returned by
+// lookup, and the #{name} tokens with the named fields. The quoting state of
+// tmpl is tracked so a value is always inserted as a single quoted word, even
+// when the token sits inside a single or double quoted string.
+func renderShell(tmpl string, lookup func(int) string, names map[string]string) string {
+	const (
+		unquoted = iota
+		single
+		double
+	)
+	var sbuff strings.Builder
+	state := unquoted
+
+	for i := 0; i < len(tmpl); i++ {
+		ch := tmpl[i]
+		if ch == '#' {
+			val, n, ok := "", 0, false
+			if field, fn, fok := fieldToken(tmpl[i:]); fok {
+				val, n, ok = lookup(field), fn, true
+			} else if name, nn, nok := nameToken(tmpl[i:]); nok {
+				val, ok = names[name]
+				n = nn
+			}
+			if ok {
+				quoted := shellQuote(val)
+				switch state {
+				case single:
+					// Close the quotes, add the word and reopen.
+					quoted = "'" + quoted + "'"
+				case double:
+					quoted = `"` + quoted + `"`
+				}
+				sbuff.WriteString(quoted)
+				i += n - 1
+				continue
+			}
+		}
+
+		sbuff.WriteByte(ch)
+		switch {
+		case ch == '\\' && state != single && i+1 < len(tmpl):
+			// Escaped characters are copied as is.
+			i++
+			sbuff.WriteByte(tmpl[i])
+		case ch == '\'' && state == unquoted:
+			state = single
+		case ch == '\'' && state == single:
+			state = unquoted
+		case ch == '"' && state == unquoted:
+			state = double
+		case ch == '"' && state == double:
+			state = unquoted
+		}
+	}
+	return sbuff.String()
+}
+
+// fieldToken parses a #{n} field token at the start of str, returning the
+// field number and the length of the token.
+func fieldToken(str string) (int, int, bool) {
+	if !strings.HasPrefix(str, `#{`) {
+		return 0, 0, false
+	}
+	end := strings.Index(str, `}`)
+	if end == -1 {
+		return 0, 0, false
+	}
+	field, err := strconv.Atoi(str[2:end])
+	if err != nil || field < 0 {
+		return 0, 0, false
+	}
+	return field, end + 1, true
+}
+
+// nameToken parses a #{name} token at the start of str, returning the name
+// and the length of the token.
+func nameToken(str string) (string, int, bool) {
+	if !strings.HasPrefix(str, `#{`) {
+		return "", 0, false
+	}
+	end := strings.Index(str, `}`)
+	if end == -1 || !isFieldName(str[2:end]) {
+		return "", 0, false
+	}
+	return str[2:end], end + 1, true
+}
+
+// shellQuote returns str quoted so that the shell treats it as a single word
+// with no expansions.
+func shellQuote(str string) string {
+	if str == "" {
+		return "''"
+	}
+	safe := true
+	for _, r := range str {
+		if !isShellSafe(r) {
+			safe = false
+			break
+		}
+	}
+	if safe {
+		return str
+	}
+	return "'" + strings.Replace(str, "'", `'\''`, -1) + "'"
+}
+
+// isShellSafe reports whether r never needs quoting in a shell word.
+func isShellSafe(r rune) bool {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		return true
+	}
+	return strings.ContainsRune("@%_+=:,./-", r)
+}
@@ -0,0 +1,143 @@
+package stream
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestShellQuote(t *testing.T) {
+	testTable := []struct {
+		str string
+		exp string
+	}{
+		{
+			str: "",
+			exp: "''",
+		},
+		{
+			str: "192.168.127.3",
+			exp: "192.168.127.3",
+		},
+		{
+			str: "two words",
+			exp: "'two words'",
+		},
+		{
+			str: "it's; rm -rf /",
+			exp: `'it'\''s; rm -rf /'`,
+		},
+		{
+			str: "$(reboot)",
+			exp: "'$(reboot)'",
+		},
+	}
+
+	for _, test := range testTable {
+		resp := shellQuote(test.str)
+		if strings.Compare(resp, test.exp) != 0 {
+			t.Errorf("response string was incorrect, expected %v, got %v", test.exp, resp)
+		}
+	}
+}
+
+func TestRenderShell(t *testing.T) {
+	fields := map[int]string{
+		1: "10.0.0.1",
+		2: "a b",
+		3: "x'; echo pwned",
+	}
+	lookup := func(field int) string {
+		return fields[field]
+	}
+
+	testTable := []struct {
+		tmpl string
+		exp  string
+	}{
+		{
+			tmpl: "echo #{1} | wc -c",
+			exp:  "echo 10.0.0.1 | wc -c",
+		},
+		{
+			tmpl: "echo #{2} > /tmp/out",
+			exp:  "echo 'a b' > /tmp/out",
+		},
+		{
+			tmpl: "echo 'ip #{2}'",
+			exp:  "echo 'ip ''a b'''",
+		},
+		{
+			tmpl: `echo "ip #{3}"`,
+			exp:  `echo "ip "'x'\''; echo pwned'""`,
+		},
+		{
+			tmpl: `echo \#{1} #{9}`,
+			exp:  `echo \#{1} ''`,
+		},
+		{
+			tmpl: "echo #{x}",
+			exp:  "echo #{x}",
+		},
+		{
+			tmpl: "logger -t #{app_name} 'from #{hostname}'",
+			exp:  `logger -t sshd 'from ''db01; reboot'''`,
+		},
+	}
+
+	names := map[string]string{
+		"app_name": "sshd",
+		"hostname": "db01; reboot",
+	}
+	for _, test := range testTable {
+		resp := renderShell(test.tmpl, lookup, names)
+		if strings.Compare(resp, test.exp) != 0 {
+			t.Errorf("response string was incorrect, expected %v, got %v", test.exp, resp)
+		}
+	}
+}
+
+func TestExecStreamCommShell(t *testing.T) {
+	dir, err := ioutil.TempDir("", "streammon")
+	if err != nil {
+		t.Fatalf("got error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "out")
+
+	s, err := NewStream(
+		".*",
+		"echo",
+		" ",
+		"",
+		[]string{`"user #{2}" | tr a-z A-Z >> ` + out},
+		Options{Shell: true},
+	)
+	if err != nil {
+		t.Fatalf("got error creating stream: %v", err)
+	}
+
+	lines := []string{
+		"login bob",
+		"login $(touch " + filepath.Join(dir, "pwned") + ")",
+	}
+	for _, line := range lines {
+		if err := s.ExecStreamComm(line); err != nil {
+			t.Errorf("got error executing command: %v", err)
+		}
+	}
+
+	resp, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatalf("got error reading output: %v", err)
+	}
+	exp := "USER BOB\nUSER $(TOUCH\n"
+	if string(resp) != exp {
+		t.Errorf("output was incorrect, expected %q, got %q", exp, string(resp))
+	}
+	if _, err := os.Stat(filepath.Join(dir, "pwned")); err == nil {
+		t.Errorf("field text was executed by the shell")
+	}
+}
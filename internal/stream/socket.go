@@ -0,0 +1,117 @@
+package stream
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+)
+
+// maxDatagram is the largest datagram a unixgram socket accepts.
+const maxDatagram = 64 * 1024
+
+// socketAddr returns the network and path of a Stream reading from a unix
+// socket, ie. its file is a unix:// or unixgram:// path.
+func socketAddr(file string) (string, string, bool) {
+	for _, network := range []string{"unix", "unixgram"} {
+		if strings.HasPrefix(file, network+"://") {
+			return network, strings.TrimPrefix(file, network+"://"), true
+		}
+	}
+	return "", "", false
+}
+
+// isFIFO returns whether the file at path is a named pipe.
+func isFIFO(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode()&os.ModeNamedPipe != 0
+}
+
+// listenSocket receives lines on the unix socket at path and publishes them.
+// Each writer connected to a stream socket, and each datagram, has its lines
+// read separately. It returns once the socket is set up, and the lines are
+// published until it fails.
+func (s *Stream) listenSocket(network, path string, swr Publisher) error {
+	// A socket left behind by a previous run would stop us listening.
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+
+	if network == "unixgram" {
+		conn, err := net.ListenPacket(network, path)
+		if err != nil {
+			return err
+		}
+		go func() {
+			buf := make([]byte, maxDatagram)
+			for {
+				n, _, err := conn.ReadFrom(buf)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "error reading %s: %s\n", s.file, err)
+					break
+				}
+				s.scanLines(bytes.NewReader(buf[:n]), s.file, nil, swr)
+			}
+			conn.Close()
+			os.Remove(path)
+			swr.Close()
+		}()
+		return nil
+	}
+
+	ln, err := net.Listen(network, path)
+	if err != nil {
+		return err
+	}
+	go func() {
+		var wg sync.WaitGroup
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error reading %s: %s\n", s.file, err)
+				break
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer conn.Close()
+				if err := s.scanLines(conn, s.file, nil, swr); err != nil {
+					fmt.Fprintf(os.Stderr, "error reading %s: %s\n", s.file, err)
+				}
+			}()
+		}
+		ln.Close()
+		// Let the writers still connected finish before closing.
+		wg.Wait()
+		swr.Close()
+	}()
+	return nil
+}
+
+// readFIFO publishes the lines written to the named pipe at the Stream's
+// file, with the path as a named field. The pipe is opened for writing as
+// well, so it stays open while no writers are connected and nothing written
+// between writers is lost. A Stream that reads once reads until the first
+// writers disconnect instead.
+func (s *Stream) readFIFO(swr Publisher) {
+	flag := os.O_RDWR
+	if s.once {
+		flag = os.O_RDONLY
+	}
+	// Opening the pipe only for reading waits for a writer.
+	file, err := os.OpenFile(s.file, flag, 0)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error reading %s: %s\n", s.file, err)
+		swr.Close()
+		return
+	}
+	defer file.Close()
+
+	fields := map[string]string{"path": s.file}
+	if err := s.scanLines(file, s.file, fields, swr); err != nil {
+		fmt.Fprintf(os.Stderr, "error reading %s: %s\n", s.file, err)
+	}
+	swr.Close()
+}
@@ -0,0 +1,140 @@
+package stream
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+// readEvents returns the next n lines from sub.
+func readEvents(t *testing.T, sub chan Event, n int) []string {
+	var lines []string
+	for len(lines) < n {
+		select {
+		case ev := <-sub:
+			lines = append(lines, ev.Line)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for lines, got %v", lines)
+		}
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+func TestListenSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "streammon")
+	if err != nil {
+		t.Fatalf("got error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	testTable := []struct {
+		network string
+		writes  [][]string
+		exp     []string
+	}{
+		{
+			// Two writers at once, the first's line is finished after
+			// the second's is written.
+			network: "unix",
+			writes:  [][]string{{"first ", "half\n"}, {"second\nthird"}},
+			exp:     []string{"first half", "second", "third"},
+		},
+		{
+			network: "unixgram",
+			writes:  [][]string{{"one\ntwo\n"}, {"three"}},
+			exp:     []string{"one", "three", "two"},
+		},
+	}
+
+	for _, test := range testTable {
+		path := filepath.Join(dir, test.network+".sock")
+		// A socket file left behind is replaced.
+		if ln, err := net.Listen("unix", path); err == nil {
+			ln.(*net.UnixListener).SetUnlinkOnClose(false)
+			ln.Close()
+		}
+		s, err := NewStream(".*", "echo", " ", test.network+"://"+path, []string{}, Options{})
+		if err != nil {
+			t.Fatalf("got error creating stream: %v", err)
+		}
+		if err := s.listenSocket(test.network, path, NewPublisher(s)); err != nil {
+			t.Fatalf("got error listening on %v: %v", test.network, err)
+		}
+
+		var conns []net.Conn
+		for _, parts := range test.writes {
+			conn, err := net.Dial(test.network, path)
+			if err != nil {
+				t.Fatalf("got error dialing %v: %v", test.network, err)
+			}
+			conns = append(conns, conn)
+			conn.Write([]byte(parts[0]))
+		}
+		for i, parts := range test.writes {
+			for _, part := range parts[1:] {
+				time.Sleep(10 * time.Millisecond)
+				conns[i].Write([]byte(part))
+			}
+			conns[i].Close()
+		}
+
+		lines := readEvents(t, s.lines, len(test.exp))
+		for i, exp := range test.exp {
+			if lines[i] != exp {
+				t.Errorf("lines over %v were incorrect, expected %q, got %q", test.network, test.exp, lines)
+				break
+			}
+		}
+	}
+}
+
+func TestReadFIFO(t *testing.T) {
+	dir, err := ioutil.TempDir("", "streammon")
+	if err != nil {
+		t.Fatalf("got error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "events")
+	if err := exec.Command("mkfifo", path).Run(); err != nil {
+		t.Skipf("can't make a named pipe: %v", err)
+	}
+	if !isFIFO(path) {
+		t.Fatalf("expected %v to be a named pipe", path)
+	}
+
+	s, err := NewStream(".*", "echo", " ", path, []string{}, Options{})
+	if err != nil {
+		t.Fatalf("got error creating stream: %v", err)
+	}
+	go s.readFIFO(NewPublisher(s))
+
+	// The pipe stays open for the second writer.
+	for _, test := range []struct {
+		text string
+		exp  []string
+	}{
+		{text: "one\ntwo\n", exp: []string{"one", "two"}},
+		{text: "three\n", exp: []string{"three"}},
+	} {
+		file, err := os.OpenFile(path, os.O_WRONLY, 0)
+		if err != nil {
+			t.Fatalf("got error opening %v: %v", path, err)
+		}
+		file.WriteString(test.text)
+		file.Close()
+
+		lines := readEvents(t, s.lines, len(test.exp))
+		for i, exp := range test.exp {
+			if lines[i] != exp {
+				t.Errorf("lines were incorrect, expected %q, got %q", test.exp, lines)
+				break
+			}
+		}
+	}
+}
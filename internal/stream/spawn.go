@@ -0,0 +1,103 @@
+package stream
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ExecSource is a long-running command whose output a Stream reads its lines
+// from, such as journalctl -f. Each line has a named field, pipe, of stdout
+// or stderr.
+type ExecSource struct {
+	Command string
+	Args    []string
+	// Stderr reads the command's stderr as well as its stdout. Otherwise
+	// it's passed through to streammon's stderr.
+	Stderr bool
+	// Backoff is the wait before restarting the command after it exits,
+	// which doubles each time it exits again soon after starting, up to
+	// MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Once reads the command's output until it exits, without restarting
+	// it.
+	Once bool
+}
+
+// String returns the command line of the source.
+func (e *ExecSource) String() string {
+	return "exec:" + strings.Join(append([]string{e.Command}, e.Args...), " ")
+}
+
+// runExec runs the Stream's command, publishing the lines it outputs, and
+// restarts it with a backoff whenever it exits.
+func (s *Stream) runExec(swr Publisher) {
+	restarts := Retry{Backoff: s.exec.Backoff, MaxBackoff: s.exec.MaxBackoff}
+	for attempt := 1; ; attempt++ {
+		start := time.Now()
+		err := s.spawn(swr)
+		if s.exec.Once {
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error running %s: %s\n", s.source(), err)
+			}
+			break
+		}
+
+		// A command that ran for a while before exiting starts the
+		// backoff over.
+		wait := restarts.delay(attempt)
+		if time.Since(start) > 2*wait {
+			attempt, wait = 1, restarts.delay(1)
+		}
+		status := "exited"
+		if err != nil {
+			status = err.Error()
+		}
+		fmt.Fprintf(os.Stderr, "%s %s, restarting in %v\n", s.source(), status, wait)
+		sleep(wait)
+	}
+	swr.Close()
+}
+
+// spawn runs the Stream's command once, publishing its lines until it exits.
+func (s *Stream) spawn(swr Publisher) error {
+	cmd := exec.Command(s.exec.Command, s.exec.Args...)
+	pipes := map[string]io.Reader{}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	pipes["stdout"] = stdout
+	if s.exec.Stderr {
+		stderr, err := cmd.StderrPipe()
+		if err != nil {
+			return err
+		}
+		pipes["stderr"] = stderr
+	} else {
+		cmd.Stderr = os.Stderr
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	// The pipes have to be read to the end before waiting for the command.
+	var wg sync.WaitGroup
+	for name, pipe := range pipes {
+		wg.Add(1)
+		go func(name string, pipe io.Reader) {
+			defer wg.Done()
+			fields := map[string]string{"pipe": name}
+			if err := s.scanLines(pipe, s.source(), fields, swr); err != nil {
+				fmt.Fprintf(os.Stderr, "error reading %s of %s: %s\n", name, s.source(), err)
+			}
+		}(name, pipe)
+	}
+	wg.Wait()
+	return cmd.Wait()
+}
@@ -0,0 +1,76 @@
+package stream
+
+import (
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestSpawnOnce(t *testing.T) {
+	s, err := NewStream(".*", "echo", " ", "", []string{}, Options{
+		Exec: &ExecSource{
+			Command: "sh",
+			Args:    []string{"-c", "echo out; echo err >&2; exit 1"},
+			Stderr:  true,
+			Once:    true,
+		},
+	})
+	if err != nil {
+		t.Fatalf("got error creating stream: %v", err)
+	}
+	if s.source() != "exec:sh -c echo out; echo err >&2; exit 1" {
+		t.Errorf("source was incorrect, got %v", s.source())
+	}
+
+	go s.runExec(NewPublisher(s))
+	got := []string{}
+	for ev := range s.lines {
+		got = append(got, ev.Fields["pipe"]+": "+ev.Line)
+	}
+	sort.Strings(got)
+	if len(got) != 2 || got[0] != "stderr: err" || got[1] != "stdout: out" {
+		t.Errorf("lines were incorrect, got %v", got)
+	}
+}
+
+func TestSpawnRestart(t *testing.T) {
+	// Stop restarting after the second wait, blocking the source for the
+	// rest of the tests.
+	waits := make(chan time.Duration, 2)
+	sleep = func(d time.Duration) {
+		waits <- d
+		if len(waits) == cap(waits) {
+			select {}
+		}
+	}
+	defer func() {
+		sleep = time.Sleep
+	}()
+
+	s, err := NewStream(".*", "echo", " ", "", []string{}, Options{
+		Exec: &ExecSource{
+			Command:    "echo",
+			Args:       []string{"started"},
+			Backoff:    time.Minute,
+			MaxBackoff: time.Hour,
+		},
+	})
+	if err != nil {
+		t.Fatalf("got error creating stream: %v", err)
+	}
+	go s.runExec(NewPublisher(s))
+
+	for i := 0; i < 2; i++ {
+		select {
+		case ev := <-s.lines:
+			if ev.Line != "started" || ev.Fields["pipe"] != "stdout" {
+				t.Errorf("line was incorrect, got %+v", ev)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for run %v", i+1)
+		}
+	}
+	if first, second := <-waits, <-waits; first != time.Minute || second != 2*time.Minute {
+		t.Errorf("expected waits of 1m and 2m, got %v and %v", first, second)
+	}
+}
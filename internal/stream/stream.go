@@ -4,22 +4,22 @@
 package stream
 
 import (
-	"bufio"
-	"bytes"
 	"fmt"
 	"os"
-	"os/exec"
 	"regexp"
 	"strconv"
 	"strings"
-
-	"github.com/hpcloud/tail"
+	"time"
 )
 
 var (
 	// LogDebug controls the logging level, when true the stream will
 	// write logs to stdout.
 	LogDebug = false
+
+	// ShellPath is the shell used to run commands for streams in shell
+	// mode.
+	ShellPath = "/bin/sh"
 )
 
 // Stream holds the information for the monitored stream.
@@ -30,14 +30,137 @@ type Stream struct {
 	args   []string
 	delim  string
 	fields []int
-	lines  chan string
+	shell  bool
+	lines  chan Event
+	exec   *ExecSource
+
+	ingestOpts IngestOptions
+
+	scanInterval time.Duration
+	drain        time.Duration
+	watch        Watch
+	pollInterval time.Duration
+	once         bool
+	format       Format
+	timestamp    *timestamp
+	encoding     Encoding
+	invalid      Invalid
+	matches      map[string]*regexp.Regexp
+	maxLine      int
+	longLines    LongLines
+	long         int64
 	// timeout int
+
+	orderKey string
+	pool     *Pool
+	retry    Retry
+	name     string
+
+	outputLimit int
+	outputLog   *outputLog
+	actions     []Action
+	actionIDs   []string
+	parallel    bool
+	onFailure   []Action
+}
+
+// Options holds the optional behaviour of a Stream.
+type Options struct {
+	// Name identifies the Stream's rule, eg. in the dead-letter file.
+	Name string
+
+	// Exec reads the lines from a command's output instead of the file.
+	Exec *ExecSource
+	// Ingest configures a Stream whose file is an http:// URL, which
+	// reads the lines POSTed to it.
+	Ingest IngestOptions
+	// ScanInterval is how often a Stream whose file is a glob or a
+	// directory looks for new files, DefaultScanInterval when zero.
+	ScanInterval time.Duration
+	// Drain is how long a file that's been moved by log rotation is still
+	// read before the new file at its path, for the lines the application
+	// writes before it reopens its log.
+	Drain time.Duration
+	// Format is how the lines read are parsed, FormatLine by default.
+	Format Format
+	// Timestamp is how the time each event occurred is read from its line.
+	Timestamp TimestampOptions
+	// Encoding is the character encoding of the lines read, which are
+	// transcoded to UTF-8. Invalid is what's done with byte sequences that
+	// aren't valid in it.
+	Encoding Encoding
+	Invalid  Invalid
+	// Match is regular expressions that named fields have to match, as
+	// well as the line matching the Stream's regexp.
+	Match map[string]string
+	// Once reads the file, or the files matching the glob, to the end
+	// and then ends the Stream, instead of following them. A compressed
+	// file is always read once.
+	Once bool
+	// Watch is how a followed file's changes are noticed, inotify by
+	// default.
+	Watch Watch
+	// MaxLine is the longest line in bytes that's read whole,
+	// DefaultMaxLine when zero. LongLines is what's done with longer
+	// lines.
+	MaxLine   int
+	LongLines LongLines
+
+	// Shell runs the command and its arguments as a single command line
+	// through ShellPath, so pipes and redirections can be used. Field
+	// values are shell quoted when substituted.
+	Shell bool
+
+	// Workers is the number of commands the Stream runs at once,
+	// DefaultWorkers when zero.
+	Workers int
+	// Queue is the number of matched lines held while every worker is
+	// busy, DefaultQueue when zero.
+	Queue int
+	// Overflow decides what happens to a matched line when the queue is
+	// full.
+	Overflow Overflow
+	// OrderKey is a field template, eg. "#{1}". Matched lines with the
+	// same key have their commands run in the order they were matched.
+	OrderKey string
+
+	// Retry is the policy for running the command again when it fails.
+	Retry Retry
+
+	// OutputLimit is the most bytes of the command's stdout and stderr
+	// kept for logs and errors, DefaultOutputLimit when zero.
+	OutputLimit int
+	// OutputLog is a file the result and output of every command is
+	// appended to.
+	OutputLog string
+
+	// HTTP makes an HTTP request for each matched line instead of
+	// running the command.
+	HTTP *HTTPOptions
+	// File appends a record to a file for each matched line instead of
+	// running the command.
+	File *FileOptions
+	// Redis sends a command to a Redis server for each matched line
+	// instead of running the command.
+	Redis *RedisOptions
+	// Syslog sends a syslog message for each matched line instead of
+	// running the command.
+	Syslog *SyslogOptions
+
+	// Actions are run for each matched line after the Stream's command or
+	// action, one after the other until one fails.
+	Actions []ActionOptions
+	// Parallel runs all of the Stream's actions at once instead.
+	Parallel bool
+	// OnFailure are run in order for a matched line when one of the
+	// Stream's actions fails.
+	OnFailure []ActionOptions
 }
 
 // Subscriber provides functions for a consumer of the Stream's output to
 // subscribe, ie. receive text coming through the stream.
 type Subscriber interface {
-	Subscribe() chan string
+	Subscribe() chan Event
 	Err() error
 	Close()
 }
@@ -45,6 +168,7 @@ type Subscriber interface {
 // Publisher provides functions to publish to any subscribers of a stream.
 type Publisher interface {
 	Publish(string)
+	PublishEvent(Event)
 	Err() error
 	Close()
 }
@@ -56,13 +180,18 @@ var (
 
 // NewStream constructs a Stream for processing of a file. This calls the
 // necessary field parsing functions before returning.
-func NewStream(pattern, cmd, delim, file string, args []string) (*Stream, error) {
+func NewStream(pattern, cmd, delim, file string, args []string, opts Options) (*Stream, error) {
 	s := Stream{
-		cmd:   cmd,
-		args:  args,
-		delim: delim,
-		file:  file,
-		lines: make(chan string),
+		cmd:      cmd,
+		args:     args,
+		delim:    delim,
+		file:     file,
+		shell:    opts.Shell,
+		retry:    opts.Retry,
+		name:     opts.Name,
+		parallel: opts.Parallel,
+		exec:     opts.Exec,
+		lines:    make(chan Event),
 		// timeout: timeout,
 	}
 	reg, err := setupRegexp(pattern)
@@ -71,40 +200,128 @@ func NewStream(pattern, cmd, delim, file string, args []string) (*Stream, error)
 	}
 	s.fields = parseFields(s.args)
 	s.Regexp = reg
+
+	s.drain = opts.Drain
+	s.once = opts.Once
+	s.format = opts.Format
+	if s.timestamp, err = newTimestamp(opts.Timestamp); err != nil {
+		return nil, err
+	}
+	s.ingestOpts = opts.Ingest
+	if _, path, ok := ingestAddr(file); ok {
+		if err := checkIngestPath(path); err != nil {
+			return nil, err
+		}
+	}
+	s.encoding, s.invalid = opts.Encoding, opts.Invalid
+	for name, pattern := range opts.Match {
+		reg, err := setupRegexp(pattern)
+		if err != nil {
+			return nil, err
+		}
+		if s.matches == nil {
+			s.matches = map[string]*regexp.Regexp{}
+		}
+		s.matches[name] = reg
+	}
+	s.scanInterval = opts.ScanInterval
+	if s.scanInterval <= 0 {
+		s.scanInterval = DefaultScanInterval
+	}
+	s.watch = opts.Watch
+	s.maxLine, s.longLines = opts.MaxLine, opts.LongLines
+	if s.maxLine <= 0 {
+		s.maxLine = DefaultMaxLine
+	}
+
+	workers, queue := opts.Workers, opts.Queue
+	if workers <= 0 {
+		workers = DefaultWorkers
+	}
+	if queue <= 0 {
+		queue = DefaultQueue
+	}
+	s.orderKey = opts.OrderKey
+
+	s.outputLimit = opts.OutputLimit
+	if s.outputLimit <= 0 {
+		s.outputLimit = DefaultOutputLimit
+	}
+	if opts.OutputLog != "" {
+		if s.outputLog, err = openOutputLog(opts.OutputLog); err != nil {
+			return nil, err
+		}
+	}
+	if cmd != "" || opts.HTTP != nil || opts.File != nil || opts.Redis != nil || opts.Syslog != nil {
+		own := ActionOptions{
+			Command: cmd,
+			Args:    args,
+			Shell:   opts.Shell,
+			HTTP:    opts.HTTP,
+			File:    opts.File,
+			Redis:   opts.Redis,
+			Syslog:  opts.Syslog,
+		}
+		a, err := s.newAction(own)
+		if err != nil {
+			return nil, err
+		}
+		s.actions = append(s.actions, a)
+		s.actionIDs = append(s.actionIDs, actionID(own))
+	}
+	more, err := s.newActions(opts.Actions)
+	if err != nil {
+		return nil, err
+	}
+	s.actions = append(s.actions, more...)
+	for _, o := range opts.Actions {
+		s.actionIDs = append(s.actionIDs, actionID(o))
+	}
+	if s.onFailure, err = s.newActions(opts.OnFailure); err != nil {
+		return nil, err
+	}
+
+	s.pool = NewPool(workers, queue, opts.Overflow, s.orderKey != "")
 	return &s, nil
 }
 
-// openScanner creates a new file scanner from the Stream -- we'll be reading from
-// stdin as there was no file included.
-func (s *Stream) openScanner() *bufio.Scanner {
-	scanner := bufio.NewScanner(os.Stdin)
-	return scanner
+// Name returns the name of the Stream's rule.
+func (s *Stream) Name() string {
+	return s.name
 }
 
-// openFile tails the Stream's file, returning the new lines back
-// via string channel.
-func (s *Stream) tailFile(swr Publisher) {
-	conf := tail.Config{
-		Follow: true,
-		Poll:   true,
-		Logger: tail.DiscardingLogger,
+// Match reports whether ev matches the Stream's regexp, and each of its
+// named fields matches the Stream's regexp for it. A field that's missing
+// doesn't match.
+func (s *Stream) Match(ev Event) bool {
+	if !s.Regexp.MatchString(ev.Line) {
+		return false
+	}
+	for name, reg := range s.matches {
+		val, ok := ev.Fields[name]
+		if !ok || !reg.MatchString(val) {
+			return false
+		}
 	}
-	t, err := tail.TailFile(s.file, conf)
+	return true
+}
+
+// source returns a name for where the Stream's lines are read from.
+func (s *Stream) source() string {
+	if s.exec != nil {
+		return s.exec.String()
+	}
+	if s.file == "" {
+		return "stdin"
+	}
+	return s.file
+}
 
-	// Catch any file closures that will cause a panic to unravel, so we
-	// can close the subscribers nicely.
+// tailFile follows the Stream's file, sending the new lines to any
+// Subscribers, and reopening it when it's rotated.
+func (s *Stream) tailFile(swr Publisher) {
 	go func() {
-		// Resend the lines back to any Subscribers.
-		for line := range t.Lines {
-			if swr.Err() == nil {
-				swr.Publish(line.Text)
-			} else {
-				swr.Close()
-			}
-			if line.Err != nil {
-				fmt.Fprintf(os.Stderr, "error reading %s: ", err)
-			}
-		}
+		s.follow(s.file, swr, nil)
 		// Close the channel, we're done tailing.
 		swr.Close()
 	}()
@@ -113,22 +330,45 @@ func (s *Stream) tailFile(swr Publisher) {
 // readLines creates a string channel that the lines of the file
 // will be sent to.
 func (s *Stream) readLines() {
-	swr := NewPublisher(s)
-	if s.file == "" {
+	swr := s.formatted(NewPublisher(s))
+	if s.exec != nil {
+		go s.runExec(swr)
+	} else if network, addr, ok := syslogAddr(s.file); ok {
+		if err := s.listenSyslog(network, addr, swr); err != nil {
+			fmt.Fprintf(os.Stderr, "error listening on %s: %s\n", s.file, err)
+			swr.Close()
+		}
+	} else if addr, path, ok := ingestAddr(s.file); ok {
+		if err := s.listenIngest(addr, path, swr); err != nil {
+			fmt.Fprintf(os.Stderr, "error listening on %s: %s\n", s.file, err)
+			swr.Close()
+		}
+	} else if network, path, ok := socketAddr(s.file); ok {
+		if err := s.listenSocket(network, path, swr); err != nil {
+			fmt.Fprintf(os.Stderr, "error listening on %s: %s\n", s.file, err)
+			swr.Close()
+		}
+	} else if s.file == "" {
 		// We're reading from stdin.
-		scanner := s.openScanner()
 		go func() {
-			for scanner.Scan() {
-				if swr.Err() == nil {
-					swr.Publish(scanner.Text())
-				}
+			if err := s.scanLines(os.Stdin, s.source(), nil, swr); err != nil {
+				fmt.Fprintf(os.Stderr, "error reading stdin: %s\n", err)
 			}
-			if err := scanner.Err(); err != nil {
-				fmt.Fprintf(os.Stderr, "error reading %s: ", err)
-			}
-			// We've exhausted the scanner.
+			// We've read all of stdin.
 			swr.Close()
 		}()
+	} else if pattern, ok := globPattern(s.file); ok {
+		if s.once {
+			go s.readOnce(globFiles(pattern), swr)
+		} else {
+			// Tail every file matching the glob, or in the directory.
+			go s.watchGlob(pattern, swr)
+		}
+	} else if isFIFO(s.file) {
+		go s.readFIFO(swr)
+	} else if s.once || compression(s.file) != "" {
+		// Read the file to the end, decompressing it if it's archived.
+		go s.readOnce([]string{s.file}, swr)
 	} else {
 		// Tail the file instead.
 		s.tailFile(swr)
@@ -136,57 +376,117 @@ func (s *Stream) readLines() {
 }
 
 // ExecStreamComm is called with a matched line from the Stream, and executes
-// the command for that stream.
+// the commands (or actions) for that stream, retrying each under the Stream's
+// Retry policy. When one fails, the Stream's on-failure actions are run and
+// the failure is returned.
 func (s *Stream) ExecStreamComm(matchLn string) error {
-	// Before running the command, we need to replace field
-	// tokens with the actual matched line fields.
-	args := prepArgs(matchLn, s)
+	return s.ExecEvent(Event{Line: matchLn})
+}
+
+// ExecEvent is ExecStreamComm for a matched line with named fields.
+func (s *Stream) ExecEvent(ev Event) error {
+	return s.ExecActions(ev, nil)
+}
+
+// ExecActions is ExecEvent running only the actions identified by pending,
+// as a dead letter records them, so the actions that succeeded aren't run
+// again. All of the actions are run when pending is empty, or has an action
+// the Stream doesn't have, as its configuration has changed. A failure is
+// returned as an *ActionError.
+func (s *Stream) ExecActions(ev Event, pending []string) error {
+	run := s.pendingActions(pending)
+	if len(run) == 0 {
+		for i := range s.actions {
+			run = append(run, i)
+		}
+	}
+
+	var err error
+	if s.parallel {
+		err = s.runParallel(ev, run)
+	} else {
+		err = s.runSequence(ev, run)
+	}
 
-	if LogDebug {
-		fmt.Printf("calling %s with args %v\n", s.cmd, args)
+	if err != nil {
+		for _, a := range s.onFailure {
+			if ferr := s.runAction(a, ev); ferr != nil {
+				fmt.Fprintf(os.Stderr, "error running on_failure action %s: \n", ferr.Error())
+			}
+		}
 	}
+	return err
+}
 
-	cmd := exec.Command(s.cmd, args...)
-	var out bytes.Buffer
-	cmd.Stdout = &out
-	if err := cmd.Run(); err != nil {
-		return err
+// pendingActions returns the indexes of the Stream's actions with the ids
+// in pending, in the order they're configured, or none if any of them
+// aren't found. An id that's repeated is matched to each action with it in
+// turn.
+func (s *Stream) pendingActions(pending []string) []int {
+	used := make([]bool, len(s.actionIDs))
+	for _, id := range pending {
+		found := false
+		for i := range s.actionIDs {
+			if !used[i] && s.actionIDs[i] == id {
+				used[i], found = true, true
+				break
+			}
+		}
+		if !found {
+			return nil
+		}
 	}
 
-	if out.String() != "" && LogDebug {
-		fmt.Printf("output: %s matched line: %s.\n", out.String(), matchLn)
+	run := []int{}
+	for i := range used {
+		if used[i] {
+			run = append(run, i)
+		}
 	}
-	return nil
+	return run
 }
 
 // prepArgs takes a line that matched the Stream's regexp, and splits it on
 // the Streams delimiter. After that, it replaces any of the field tokens with
 // the actual field.
 func prepArgs(line string, s *Stream) []string {
-	spl := strings.Split(line, s.delim)
-	preppedArgs := []string{}
+	return prepEventArgs(Event{Line: line}, s)
+}
 
-	// For all of the arguments, we want to replace any of the field tokens
-	// with the actual field. The output of this loop should be the arg
-	// string with the log line including the actual field text instead of
-	// the token.
+// prepEventArgs is prepArgs for a matched line with named fields, replacing
+// both kinds of token at once.
+func prepEventArgs(ev Event, s *Stream) []string {
+	lookup := lineFields(ev.Line, s.delim)
+	names := ev.names()
+	preppedArgs := []string{}
 	for _, argStr := range s.args {
-		for _, field := range s.fields {
-			if len(spl) >= field {
-				if field == 0 {
-					argStr = insertField(argStr, line, field)
-				} else {
-					// Field tokens start from index 1 (unless
-					// referring to the whole line).
-					argStr = insertField(argStr, spl[field-1], field)
-				}
-			}
-		}
-		preppedArgs = append(preppedArgs, argStr)
+		preppedArgs = append(preppedArgs, renderTokens(argStr, lookup, names, nil))
 	}
 	return preppedArgs
 }
 
+// lineFields returns a lookup of the fields of line split on delim.
+func lineFields(line, delim string) func(int) (string, bool) {
+	spl := strings.Split(line, delim)
+	return func(field int) (string, bool) {
+		return fieldValue(line, spl, field)
+	}
+}
+
+// fieldValue returns the text for a field token from a line split on the
+// Stream's delimiter, or false when the line doesn't have that field.
+func fieldValue(line string, spl []string, field int) (string, bool) {
+	if field < 0 || len(spl) < field {
+		return "", false
+	}
+	if field == 0 {
+		return line, true
+	}
+	// Field tokens start from index 1 (unless referring to the whole
+	// line).
+	return spl[field-1], true
+}
+
 // insertField replaces the field tokens with the field text.
 // For example if the string was "this is my #{5} field" and the 5th field was
 // "log", the output is "this is my log field".
@@ -202,10 +502,18 @@ func parseFields(args []string) []int {
 	for _, arg := range args {
 		ind := strings.Index(arg, `#{`)
 		for ind != -1 {
-			token := strings.Split(arg[ind+2:], `}`)[0]
-			if i, err := strconv.Atoi(token); err != nil {
-				fmt.Fprintf(os.Stderr, "error parsing fields: %s", err)
+			end := strings.Index(arg[ind+2:], `}`)
+			if end == -1 {
+				// The token isn't closed, so it's just text.
 				break
+			}
+			token := arg[ind+2 : ind+2+end]
+			if i, err := strconv.Atoi(token); err != nil {
+				// Named fields are filled in from the Event instead.
+				if !isFieldName(token) {
+					fmt.Fprintf(os.Stderr, "error parsing fields: %s", err)
+					break
+				}
 			} else {
 				fields = append(fields, i)
 			}
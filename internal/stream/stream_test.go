@@ -140,6 +140,19 @@ func TestParseFields(t *testing.T) {
 			},
 			exp: []int{0},
 		},
+		{
+			args: []string{
+				"#{hostname}:#{2}",
+			},
+			exp: []int{2},
+		},
+		{
+			args: []string{
+				"#{1} says #{host",
+				"#{3",
+			},
+			exp: []int{1},
+		},
 	}
 
 	for _, test := range testTable {
@@ -0,0 +1,296 @@
+package stream
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var (
+	// DefaultSyslogSocket is the local syslog socket used by a syslog
+	// action that doesn't set a network.
+	DefaultSyslogSocket = "/dev/log"
+	// DefaultSyslogSDID is the SD-ID of the structured data element sent
+	// by a syslog action that doesn't set its own.
+	DefaultSyslogSDID = "streammon@32473"
+	// DefaultSyslogTimeout is the timeout for connecting and writing to
+	// the server when a syslog action doesn't set its own.
+	DefaultSyslogTimeout = 5 * time.Second
+)
+
+// facilities are the syslog facility names and their codes.
+var facilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+	"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// severities are the syslog severity names and their codes.
+var severities = map[string]int{
+	"emerg": 0, "alert": 1, "crit": 2, "err": 3, "error": 3,
+	"warning": 4, "warn": 4, "notice": 5, "info": 6, "debug": 7,
+}
+
+// ParseFacility returns the code of a syslog facility name, eg. local0.
+func ParseFacility(name string) (int, error) {
+	if code, ok := facilities[strings.ToLower(name)]; ok {
+		return code, nil
+	}
+	return 0, fmt.Errorf("unknown syslog facility %s", name)
+}
+
+// ParseSeverity returns the code of a syslog severity name, eg. warning.
+func ParseSeverity(name string) (int, error) {
+	if code, ok := severities[strings.ToLower(name)]; ok {
+		return code, nil
+	}
+	return 0, fmt.Errorf("unknown syslog severity %s", name)
+}
+
+// SyslogOptions configures an RFC 5424 syslog message sent for each matched
+// line. The message, message ID and structured data can contain field
+// tokens.
+type SyslogOptions struct {
+	// Network is udp, tcp or unix, with Addr the host:port or socket
+	// path. When empty, the message goes to the local DefaultSyslogSocket.
+	Network string
+	Addr    string
+
+	// Facility and Severity are names such as local0 and warning, user
+	// and notice when empty.
+	Facility string
+	Severity string
+	// Tag is the APP-NAME of the message, "streammon" when empty.
+	Tag string
+	// Hostname is the HOSTNAME of the message, this host's name when
+	// empty.
+	Hostname string
+	MsgID    string
+	// Message is the MSG, "#{0}" when empty.
+	Message string
+	// StructuredData are the parameters of a single structured data
+	// element with the ID SDID.
+	StructuredData map[string]string
+	SDID           string
+
+	Timeout time.Duration
+}
+
+// syslogAction sends a syslog message for each matched line.
+type syslogAction struct {
+	lock     sync.Mutex
+	opts     SyslogOptions
+	pri      int
+	network  string
+	msgID    template
+	message  template
+	sdNames  []string
+	sdValues map[string]template
+	conn     net.Conn
+}
+
+// newSyslogAction creates the action from its options, with the fields split
+// on delim.
+func newSyslogAction(opts SyslogOptions, delim string) (*syslogAction, error) {
+	if opts.Facility == "" {
+		opts.Facility = "user"
+	}
+	facility, err := ParseFacility(opts.Facility)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Severity == "" {
+		opts.Severity = "notice"
+	}
+	severity, err := ParseSeverity(opts.Severity)
+	if err != nil {
+		return nil, err
+	}
+
+	switch opts.Network {
+	case "":
+		opts.Network, opts.Addr = "unix", DefaultSyslogSocket
+	case "udp", "tcp", "unix":
+		if opts.Addr == "" {
+			return nil, errors.New("a syslog action needs an addr for its network")
+		}
+	default:
+		return nil, errors.New("a syslog action's network must be udp, tcp or unix")
+	}
+	if opts.Tag == "" {
+		opts.Tag = "streammon"
+	}
+	if opts.Hostname == "" {
+		opts.Hostname, _ = os.Hostname()
+	}
+	if opts.Message == "" {
+		opts.Message = "#{0}"
+	}
+	if opts.SDID == "" {
+		opts.SDID = DefaultSyslogSDID
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultSyslogTimeout
+	}
+
+	a := syslogAction{
+		opts:     opts,
+		pri:      facility*8 + severity,
+		msgID:    newTemplate(opts.MsgID, delim, nil),
+		message:  newTemplate(opts.Message, delim, nil),
+		sdValues: map[string]template{},
+	}
+	for name, val := range opts.StructuredData {
+		a.sdNames = append(a.sdNames, name)
+		a.sdValues[name] = newTemplate(val, delim, sdEscape)
+	}
+	sort.Strings(a.sdNames)
+	return &a, nil
+}
+
+// Run sends the message for a matched line, dialing the server again if
+// the connection has failed.
+func (a *syslogAction) Run(ev Event) error {
+	msg := a.format(ev, ev.when())
+	if LogDebug {
+		fmt.Printf("calling syslog %s\n", msg)
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	// A connection that was dropped is only noticed when writing to it, so
+	// one failed write is tried again on a new connection.
+	for attempt := 0; ; attempt++ {
+		reused := a.conn != nil
+		if reused && a.closed() {
+			a.conn.Close()
+			a.conn, reused = nil, false
+		}
+		if !reused {
+			if err := a.dial(); err != nil {
+				return err
+			}
+		}
+		a.conn.SetWriteDeadline(time.Now().Add(a.opts.Timeout))
+		_, err := a.conn.Write(a.frame(msg))
+		if err == nil {
+			return nil
+		}
+		a.conn.Close()
+		a.conn = nil
+		if !reused || attempt > 0 {
+			return err
+		}
+	}
+}
+
+// closed reports whether the server has closed a stream connection.
+func (a *syslogAction) closed() bool {
+	if a.network != "tcp" && a.network != "unix" {
+		return false
+	}
+	return peerClosed(a.conn)
+}
+
+// peerClosed reports whether the other end has closed a stream connection
+// that it never writes to unasked, so peeking finds either nothing waiting
+// or the end of the connection.
+func peerClosed(conn net.Conn) bool {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return false
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return false
+	}
+
+	closed := false
+	raw.Read(func(fd uintptr) bool {
+		closed = peekClosed(fd)
+		return true
+	})
+	return closed
+}
+
+// dial connects to the syslog server. A unix socket is tried as a datagram
+// socket first, which is what /dev/log usually is.
+func (a *syslogAction) dial() error {
+	var err error
+	if a.opts.Network == "unix" {
+		if a.conn, err = net.DialTimeout("unixgram", a.opts.Addr, a.opts.Timeout); err == nil {
+			a.network = "unixgram"
+			return nil
+		}
+	}
+	a.conn, err = net.DialTimeout(a.opts.Network, a.opts.Addr, a.opts.Timeout)
+	a.network = a.opts.Network
+	return err
+}
+
+// frame returns the bytes written for a message. TCP uses octet counting,
+// and unix stream sockets end each message with a newline.
+func (a *syslogAction) frame(msg string) []byte {
+	switch a.network {
+	case "tcp":
+		return []byte(fmt.Sprintf("%v %s", len(msg), msg))
+	case "unix":
+		return []byte(msg + "\n")
+	}
+	return []byte(msg)
+}
+
+// format returns the RFC 5424 message for a matched line.
+func (a *syslogAction) format(ev Event, now time.Time) string {
+	sd := "-"
+	if len(a.sdNames) > 0 {
+		var sbuff strings.Builder
+		sbuff.WriteString("[" + a.opts.SDID)
+		for _, name := range a.sdNames {
+			sbuff.WriteString(fmt.Sprintf(` %s="%s"`, name, a.sdValues[name].render(ev)))
+		}
+		sbuff.WriteString("]")
+		sd = sbuff.String()
+	}
+
+	return fmt.Sprintf("<%v>1 %s %s %s %v %s %s %s",
+		a.pri,
+		now.Format("2006-01-02T15:04:05.000000Z07:00"),
+		headerField(a.opts.Hostname, 255),
+		headerField(a.opts.Tag, 48),
+		os.Getpid(),
+		headerField(a.msgID.render(ev), 32),
+		sd,
+		a.message.render(ev))
+}
+
+// headerField makes str a valid RFC 5424 header field, "-" when it's empty,
+// with no spaces and at most max characters.
+func headerField(str string, max int) string {
+	str = strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' {
+			return '_'
+		}
+		return r
+	}, str)
+	if str == "" {
+		return "-"
+	}
+	if len(str) > max {
+		str = str[:max]
+	}
+	return str
+}
+
+// sdEscape escapes a structured data parameter value.
+func sdEscape(str string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(str)
+}
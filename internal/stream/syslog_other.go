@@ -0,0 +1,10 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris)
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package stream
+
+// peekClosed can't peek at a socket on this platform, so a closed connection
+// is only found when writing to it fails.
+func peekClosed(fd uintptr) bool {
+	return false
+}
@@ -0,0 +1,161 @@
+package stream
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseFacilitySeverity(t *testing.T) {
+	if code, err := ParseFacility("local3"); code != 19 || err != nil {
+		t.Errorf("expected local3 to be 19, got %v, %v", code, err)
+	}
+	if code, err := ParseSeverity("WARNING"); code != 4 || err != nil {
+		t.Errorf("expected WARNING to be 4, got %v, %v", code, err)
+	}
+	if _, err := ParseFacility("local9"); err == nil {
+		t.Errorf("errors not returned for an unknown facility")
+	}
+	if _, err := ParseSeverity("loud"); err == nil {
+		t.Errorf("errors not returned for an unknown severity")
+	}
+}
+
+func TestSyslogFormat(t *testing.T) {
+	a, err := newSyslogAction(SyslogOptions{
+		Network:  "udp",
+		Addr:     "127.0.0.1:514",
+		Facility: "local0",
+		Severity: "warning",
+		Tag:      "dhcp watch",
+		Hostname: "fitzy",
+		MsgID:    "#{1}",
+		Message:  "lease for #{3}",
+		StructuredData: map[string]string{
+			"mac": "#{5}",
+			"ip":  "#{3}",
+		},
+	}, " ")
+	if err != nil {
+		t.Fatalf("got error creating action: %v", err)
+	}
+
+	now := time.Date(2022, time.January, 5, 7, 8, 9, 123456000, time.UTC)
+	resp := a.format(Event{Line: `DHCPREQUEST for 192.168.127.3 from "mac]\"`}, now)
+	exp := fmt.Sprintf(`<132>1 2022-01-05T07:08:09.123456Z fitzy dhcp_watch %v DHCPREQUEST `+
+		`[streammon@32473 ip="192.168.127.3" mac="\"mac\]\\\""] lease for 192.168.127.3`, os.Getpid())
+	if resp != exp {
+		t.Errorf("message was incorrect, expected\n%v\ngot\n%v", exp, resp)
+	}
+
+	a, _ = newSyslogAction(SyslogOptions{Network: "udp", Addr: "127.0.0.1:514", Hostname: "fitzy"}, " ")
+	resp = a.format(Event{Line: "plain line"}, now)
+	exp = fmt.Sprintf(`<13>1 2022-01-05T07:08:09.123456Z fitzy streammon %v - - plain line`, os.Getpid())
+	if resp != exp {
+		t.Errorf("message was incorrect, expected\n%v\ngot\n%v", exp, resp)
+	}
+}
+
+func TestSyslogTransports(t *testing.T) {
+	dir, err := ioutil.TempDir("", "streammon")
+	if err != nil {
+		t.Fatalf("got error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// UDP and unix datagram sockets get one message per datagram.
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("got error listening: %v", err)
+	}
+	defer udp.Close()
+	sock := filepath.Join(dir, "log")
+	unixgram, err := net.ListenPacket("unixgram", sock)
+	if err != nil {
+		t.Fatalf("got error listening: %v", err)
+	}
+	defer unixgram.Close()
+
+	testTable := []struct {
+		opts SyslogOptions
+		conn net.PacketConn
+	}{
+		{opts: SyslogOptions{Network: "udp", Addr: udp.LocalAddr().String()}, conn: udp},
+		{opts: SyslogOptions{Network: "unix", Addr: sock}, conn: unixgram},
+	}
+	for _, test := range testTable {
+		s, err := NewStream(".*", "", " ", "", []string{}, Options{Syslog: &test.opts})
+		if err != nil {
+			t.Fatalf("got error creating stream: %v", err)
+		}
+		ev := Event{Line: "hello syslog", Time: time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)}
+		if err := s.ExecEvent(ev); err != nil {
+			t.Fatalf("got error executing action: %v", err)
+		}
+
+		buf := make([]byte, 1024)
+		test.conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := test.conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("got error reading %v: %v", test.opts.Network, err)
+		}
+		if msg := string(buf[:n]); !strings.HasPrefix(msg, "<13>1 2019-03-01T12:00:00.000000Z ") || !strings.HasSuffix(msg, " - - hello syslog") {
+			t.Errorf("message over %v was incorrect, got %q", test.opts.Network, msg)
+		}
+	}
+}
+
+func TestSyslogTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("got error listening: %v", err)
+	}
+	defer ln.Close()
+
+	// The server hangs up after each message, so the action has to dial
+	// again.
+	msgs := make(chan string, 3)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			rd := bufio.NewReader(conn)
+			var n int
+			if _, err := fmt.Fscanf(rd, "%d ", &n); err != nil {
+				conn.Close()
+				continue
+			}
+			buf := make([]byte, n)
+			io.ReadFull(rd, buf)
+			conn.Close()
+			msgs <- string(buf)
+		}
+	}()
+
+	a, err := newSyslogAction(SyslogOptions{Network: "tcp", Addr: ln.Addr().String()}, " ")
+	if err != nil {
+		t.Fatalf("got error creating action: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := a.Run(Event{Line: fmt.Sprintf("message %v", i)}); err != nil {
+			t.Fatalf("got error executing action: %v", err)
+		}
+		select {
+		case msg := <-msgs:
+			if !strings.HasSuffix(msg, fmt.Sprintf(" - - message %v", i)) {
+				t.Errorf("message was incorrect, got %q", msg)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for message %v", i)
+		}
+	}
+}
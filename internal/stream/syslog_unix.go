@@ -0,0 +1,13 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package stream
+
+import "syscall"
+
+// peekClosed reports whether the stream socket fd has reached the end of the
+// connection, without reading anything waiting or blocking.
+func peekClosed(fd uintptr) bool {
+	n, _, err := syscall.Recvfrom(int(fd), make([]byte, 1), syscall.MSG_PEEK|syscall.MSG_DONTWAIT)
+	return n == 0 && err == nil || err != nil && err != syscall.EAGAIN
+}
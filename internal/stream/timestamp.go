@@ -0,0 +1,158 @@
+package stream
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TimestampOptions configures how a Stream reads the time each event
+// occurred from its line. Events without a timestamp, or whose timestamp
+// doesn't parse, have the time they were read.
+type TimestampOptions struct {
+	// Layout is one of the named layouts rfc3339, syslog, nginx or epoch,
+	// or a Go time layout.
+	Layout string
+	// Pattern is a regular expression whose first capture group (or the
+	// whole match, without one) is the timestamp. It's required for a Go
+	// layout, the named layouts have their own.
+	Pattern string
+	// Field reads the timestamp from a named field instead of the line.
+	Field string
+	// Location is the time zone of timestamps without one, the local time
+	// zone when it's empty.
+	Location string
+}
+
+// namedLayouts are the Go layouts, and the patterns that find them in a
+// line, of the named layouts. epoch is parsed as a number instead.
+var namedLayouts = map[string]struct {
+	layout  string
+	pattern string
+}{
+	"rfc3339": {time.RFC3339Nano, `\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}:\d{2}(?:\.\d+)?(?:Z|[+-]\d{2}:?\d{2})`},
+	"syslog":  {time.Stamp, `[A-Z][a-z]{2} [ \d]\d \d{2}:\d{2}:\d{2}`},
+	"nginx":   {"02/Jan/2006:15:04:05 -0700", `\d{2}/[A-Z][a-z]{2}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}`},
+	"epoch":   {"", `\b\d{10,19}(?:\.\d+)?\b`},
+}
+
+// timestamp reads the event time of the lines of a Stream.
+type timestamp struct {
+	layout  string
+	epoch   bool
+	pattern *regexp.Regexp
+	field   string
+	loc     *time.Location
+	// now is the current time, replaced in tests.
+	now func() time.Time
+}
+
+// newTimestamp returns the timestamp reader for opts, or nil when there's
+// no layout.
+func newTimestamp(opts TimestampOptions) (*timestamp, error) {
+	if opts.Layout == "" {
+		return nil, nil
+	}
+	ts := timestamp{layout: opts.Layout, field: opts.Field, loc: time.Local, now: time.Now}
+	pattern := opts.Pattern
+	if named, ok := namedLayouts[strings.ToLower(opts.Layout)]; ok {
+		ts.layout, ts.epoch = named.layout, named.layout == ""
+		if pattern == "" {
+			pattern = named.pattern
+		}
+	} else if pattern == "" && opts.Field == "" {
+		return nil, errors.New("a timestamp with a custom layout needs a pattern or a field")
+	}
+
+	if pattern != "" && opts.Field == "" {
+		reg, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		ts.pattern = reg
+	}
+	if opts.Location != "" {
+		loc, err := time.LoadLocation(opts.Location)
+		if err != nil {
+			return nil, err
+		}
+		ts.loc = loc
+	}
+	return &ts, nil
+}
+
+// parse returns the time ev occurred, false if it doesn't have a timestamp
+// that parses.
+func (ts *timestamp) parse(ev Event) (time.Time, bool) {
+	str := ev.Line
+	if ts.field != "" {
+		var ok bool
+		if str, ok = ev.Fields[ts.field]; !ok {
+			return time.Time{}, false
+		}
+	} else if ts.pattern != nil {
+		match := ts.pattern.FindStringSubmatch(ev.Line)
+		if match == nil {
+			return time.Time{}, false
+		}
+		str = match[0]
+		if len(match) > 1 {
+			str = match[1]
+		}
+	}
+	str = strings.TrimSpace(str)
+
+	if ts.epoch {
+		return parseEpoch(str)
+	}
+	t, err := time.ParseInLocation(ts.layout, str, ts.loc)
+	if err != nil {
+		return time.Time{}, false
+	}
+	// A timestamp without a year, such as syslog's, is in the last year,
+	// or the last leap year for Feb 29.
+	if t.Year() == 0 {
+		now := ts.now().In(ts.loc)
+		for year := now.Year(); year > now.Year()-8; year-- {
+			d := time.Date(year, t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+			if d.Day() == t.Day() && !d.After(now.AddDate(0, 0, 1)) {
+				return d, true
+			}
+		}
+	}
+	return t, true
+}
+
+// parseEpoch parses a Unix time in seconds, milliseconds, microseconds or
+// nanoseconds, by its number of digits, with an optional fraction.
+func parseEpoch(str string) (time.Time, bool) {
+	whole, frac := str, ""
+	if i := strings.Index(str, "."); i >= 0 {
+		whole, frac = str[:i], str[i+1:]
+	}
+	n, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	// The number of digits of each unit after seconds.
+	scale := map[int]int64{10: 1e9, 13: 1e6, 16: 1e3, 19: 1}[len(whole)]
+	if scale == 0 {
+		return time.Time{}, false
+	}
+	nsec := n * scale
+	if frac != "" && scale > 1 {
+		for _, c := range frac {
+			if c < '0' || c > '9' {
+				return time.Time{}, false
+			}
+		}
+		// The fraction is read as the nanoseconds it's a number of,
+		// padded or cut to the digits of the unit.
+		digits := len(strconv.FormatInt(scale, 10)) - 1
+		f, _ := strconv.ParseInt((frac + "000000000")[:digits], 10, 64)
+		nsec += f
+	}
+	return time.Unix(0, nsec), true
+}
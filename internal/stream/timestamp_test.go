@@ -0,0 +1,159 @@
+package stream
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimestampParse(t *testing.T) {
+	utc := time.UTC
+	testTable := []struct {
+		opts TimestampOptions
+		ev   Event
+		exp  time.Time
+		ok   bool
+	}{
+		{
+			opts: TimestampOptions{Layout: "rfc3339"},
+			ev:   Event{Line: `level=error ts=2023-10-11T22:14:15.003+02:00 msg="db down"`},
+			exp:  time.Date(2023, 10, 11, 20, 14, 15, 3e6, utc),
+			ok:   true,
+		},
+		{
+			opts: TimestampOptions{Layout: "nginx"},
+			ev:   Event{Line: `10.0.0.9 - - [11/Oct/2023:22:14:15 +0000] "GET / HTTP/1.1" 500 612`},
+			exp:  time.Date(2023, 10, 11, 22, 14, 15, 0, utc),
+			ok:   true,
+		},
+		{
+			// Syslog timestamps are in the last year, so December is
+			// last year in January.
+			opts: TimestampOptions{Layout: "syslog", Location: "UTC"},
+			ev:   Event{Line: "Dec 31 23:59:58 fw01 sshd[4721]: Failed password"},
+			exp:  time.Date(2023, 12, 31, 23, 59, 58, 0, utc),
+			ok:   true,
+		},
+		{
+			opts: TimestampOptions{Layout: "syslog", Location: "UTC"},
+			ev:   Event{Line: "Jan  2 03:04:05 fw01 sshd[4721]: Failed password"},
+			exp:  time.Date(2024, 1, 2, 3, 4, 5, 0, utc),
+			ok:   true,
+		},
+		{
+			// Feb 29 is in the last leap year.
+			opts: TimestampOptions{Layout: "syslog", Location: "UTC"},
+			ev:   Event{Line: "Feb 29 12:00:00 fw01 sshd[4721]: Failed password"},
+			exp:  time.Date(2020, 2, 29, 12, 0, 0, 0, utc),
+			ok:   true,
+		},
+		{
+			opts: TimestampOptions{Layout: "syslog", Location: "UTC"},
+			ev:   Event{Line: "Mar  1 12:00:00 fw01 sshd[4721]: Failed password"},
+			exp:  time.Date(2023, 3, 1, 12, 0, 0, 0, utc),
+			ok:   true,
+		},
+		{
+			opts: TimestampOptions{Layout: "epoch"},
+			ev:   Event{Line: "1697062455.25 job 12 failed"},
+			exp:  time.Date(2023, 10, 11, 22, 14, 15, 25e7, utc),
+			ok:   true,
+		},
+		{
+			opts: TimestampOptions{Layout: "epoch"},
+			ev:   Event{Line: "1697062455.123456789 job 12 failed"},
+			exp:  time.Date(2023, 10, 11, 22, 14, 15, 123456789, utc),
+			ok:   true,
+		},
+		{
+			opts: TimestampOptions{Layout: "epoch"},
+			ev:   Event{Line: "1697062455003.0071 job 12 failed"},
+			exp:  time.Date(2023, 10, 11, 22, 14, 15, 3007100, utc),
+			ok:   true,
+		},
+		{
+			opts: TimestampOptions{Layout: "epoch"},
+			ev:   Event{Line: "ts=1697062455003 job 12 failed"},
+			exp:  time.Date(2023, 10, 11, 22, 14, 15, 3e6, utc),
+			ok:   true,
+		},
+		{
+			opts: TimestampOptions{Layout: "2006/01/02 15:04:05", Pattern: `^\[(.+?)\]`, Location: "UTC"},
+			ev:   Event{Line: "[2023/10/11 22:14:15] backup failed"},
+			exp:  time.Date(2023, 10, 11, 22, 14, 15, 0, utc),
+			ok:   true,
+		},
+		{
+			opts: TimestampOptions{Layout: "rfc3339", Field: "timestamp"},
+			ev:   Event{Line: "panic", Fields: map[string]string{"timestamp": "2023-10-11T22:14:15.003000000Z"}},
+			exp:  time.Date(2023, 10, 11, 22, 14, 15, 3e6, utc),
+			ok:   true,
+		},
+		{
+			opts: TimestampOptions{Layout: "rfc3339", Field: "timestamp"},
+			ev:   Event{Line: "2023-10-11T22:14:15Z panic"},
+		},
+		{
+			opts: TimestampOptions{Layout: "nginx"},
+			ev:   Event{Line: "no timestamp here"},
+		},
+		{
+			opts: TimestampOptions{Layout: "2006/01/02", Pattern: `(\S+)`},
+			ev:   Event{Line: "yesterday"},
+		},
+	}
+
+	for _, test := range testTable {
+		ts, err := newTimestamp(test.opts)
+		if err != nil {
+			t.Fatalf("got error for %+v: %v", test.opts, err)
+		}
+		ts.now = func() time.Time { return time.Date(2024, 1, 10, 0, 0, 0, 0, utc) }
+		got, ok := ts.parse(test.ev)
+		if ok != test.ok || !got.Equal(test.exp) {
+			t.Errorf("time of %q was incorrect, expected %v %v, got %v %v", test.ev.Line, test.exp, test.ok, got, ok)
+		}
+	}
+}
+
+func TestNewTimestamp(t *testing.T) {
+	testTable := []struct {
+		opts TimestampOptions
+		err  bool
+	}{
+		{opts: TimestampOptions{}},
+		{opts: TimestampOptions{Layout: "RFC3339"}},
+		{opts: TimestampOptions{Layout: "2006-01-02"}, err: true},
+		{opts: TimestampOptions{Layout: "2006-01-02", Field: "date"}},
+		{opts: TimestampOptions{Layout: "epoch", Pattern: "("}, err: true},
+		{opts: TimestampOptions{Layout: "syslog", Location: "Mars/Olympus_Mons"}, err: true},
+	}
+
+	for _, test := range testTable {
+		if _, err := newTimestamp(test.opts); (err != nil) != test.err {
+			t.Errorf("error for %+v was incorrect, expected %v, got %v", test.opts, test.err, err)
+		}
+	}
+}
+
+func TestEventTime(t *testing.T) {
+	s, err := NewStream(".*", "echo", " ", "", []string{"#{ts}"}, Options{
+		Timestamp: TimestampOptions{Layout: "rfc3339"},
+	})
+	if err != nil {
+		t.Fatalf("got error creating stream: %v", err)
+	}
+	s.lines = make(chan Event, 2)
+	swr := s.formatted(NewPublisher(s))
+	before := time.Now()
+	swr.Publish("2023-10-11T22:14:15Z backup failed")
+	swr.Publish("backup failed")
+
+	ev := <-s.lines
+	if exp := "2023-10-11T22:14:15Z"; ev.names()["ts"] != exp {
+		t.Errorf("ts was incorrect, expected %v, got %v", exp, ev.names()["ts"])
+	}
+	// Without a timestamp, it's the time the line was read.
+	if ev := <-s.lines; ev.Time.Before(before) || ev.Time.After(time.Now()) {
+		t.Errorf("expected the time the line was read, got %v", ev.Time)
+	}
+}
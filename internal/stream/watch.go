@@ -0,0 +1,77 @@
+package stream
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/hpcloud/tail/watch"
+)
+
+// Watch is how a Stream notices that the files it's following have changed.
+type Watch int
+
+const (
+	// WatchInotify is woken by inotify when a file changes,
+	// falling back to WatchPoll on filesystems that don't support it.
+	WatchInotify Watch = iota
+	// WatchPoll checks the files every poll interval.
+	WatchPoll
+)
+
+const (
+	// DefaultPollInterval is how often polled files are checked for new
+	// lines and rotation, and a file being drained for new lines, unless
+	// it's set with SetPollInterval.
+	DefaultPollInterval = 250 * time.Millisecond
+)
+
+// pollInterval is how often polled files are checked, as a time.Duration.
+var pollInterval = int64(DefaultPollInterval)
+
+// SetPollInterval sets how often every polled file is checked. The tail
+// keeps the interval for all of them, so it must be called before any
+// Streams are read, d <= 0 is DefaultPollInterval.
+func SetPollInterval(d time.Duration) {
+	if d <= 0 {
+		d = DefaultPollInterval
+	}
+	atomic.StoreInt64(&pollInterval, int64(d))
+	watch.POLL_DURATION = d
+}
+
+// ParseWatch returns the Watch for its config name, an empty string is
+// WatchInotify.
+func ParseWatch(str string) (Watch, error) {
+	switch str {
+	case "", "inotify":
+		return WatchInotify, nil
+	case "poll":
+		return WatchPoll, nil
+	}
+	return WatchInotify, errors.New("watch must be one of inotify or poll")
+}
+
+// pollFile returns whether the file at path is polled rather than watched
+// with inotify: when the Stream's watch is WatchPoll, or when inotify can't
+// be used for it.
+func pollFile(path string, w Watch) bool {
+	if w == WatchPoll {
+		return true
+	}
+	dir := filepath.Dir(path)
+	if remoteFS(dir) {
+		if LogDebug {
+			fmt.Printf("%s is on a remote filesystem, polling it\n", path)
+		}
+		return true
+	}
+	if err := inotifyErr(dir); err != nil {
+		fmt.Fprintf(os.Stderr, "can't watch %s with inotify, polling it: %s\n", path, err)
+		return true
+	}
+	return false
+}
@@ -0,0 +1,38 @@
+package stream
+
+import "golang.org/x/sys/unix"
+
+// remoteFSTypes are the filesystems inotify doesn't see other hosts' (or
+// other layers') writes on, so files on them are polled.
+var remoteFSTypes = map[uint32]bool{
+	0x6969:     true, // NFS
+	0x517B:     true, // SMB
+	0xFF534D42: true, // CIFS
+	0xFE534D42: true, // SMB2
+	0x65735546: true, // FUSE
+	0x794C7630: true, // overlay
+}
+
+// remoteFS returns whether dir is on a filesystem inotify can't watch.
+func remoteFS(dir string) bool {
+	var fs unix.Statfs_t
+	if err := unix.Statfs(dir, &fs); err != nil {
+		return false
+	}
+	// Type is signed on 32-bit platforms, where the magic numbers with the
+	// top bit set would be sign extended.
+	return remoteFSTypes[uint32(fs.Type)]
+}
+
+// inotifyErr returns the error watching dir with inotify, such as when the
+// limit on watches or instances has been reached, which the tail can't
+// recover from.
+func inotifyErr(dir string) error {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+	_, err = unix.InotifyAddWatch(fd, dir, unix.IN_MODIFY)
+	return err
+}
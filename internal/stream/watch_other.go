@@ -0,0 +1,16 @@
+//go:build !linux
+// +build !linux
+
+package stream
+
+// remoteFS returns whether dir is on a filesystem inotify can't watch, which
+// is only known on Linux.
+func remoteFS(dir string) bool {
+	return false
+}
+
+// inotifyErr returns the error watching dir with inotify, which is only
+// checked on Linux.
+func inotifyErr(dir string) error {
+	return nil
+}
@@ -0,0 +1,108 @@
+package stream
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestParseWatch(t *testing.T) {
+	testTable := []struct {
+		str   string
+		watch Watch
+		err   bool
+	}{
+		{str: "", watch: WatchInotify},
+		{str: "inotify", watch: WatchInotify},
+		{str: "poll", watch: WatchPoll},
+		{str: "fanotify", err: true},
+	}
+
+	for _, test := range testTable {
+		watch, err := ParseWatch(test.str)
+		if (err != nil) != test.err {
+			t.Errorf("error for %q was incorrect, expected %v, got %v", test.str, test.err, err)
+		}
+		if watch != test.watch {
+			t.Errorf("watch for %q was incorrect, expected %v, got %v", test.str, test.watch, watch)
+		}
+	}
+}
+
+func TestFollowWatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "streammon")
+	if err != nil {
+		t.Fatalf("got error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The inotify stream wouldn't see the line in time by polling. The
+	// interval isn't restored, as the tail's pollers outlive it a little
+	// while reading it.
+	testTable := []struct {
+		watch Watch
+		poll  time.Duration
+	}{
+		{watch: WatchInotify, poll: time.Minute},
+		{watch: WatchPoll, poll: 10 * time.Millisecond},
+	}
+
+	for i, test := range testTable {
+		path := filepath.Join(dir, "messages"+string(rune('a'+i)))
+		if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+			t.Fatalf("got error creating %v: %v", path, err)
+		}
+		SetPollInterval(test.poll)
+		s, err := NewStream(".*", "echo", " ", path, []string{}, Options{Watch: test.watch})
+		if err != nil {
+			t.Fatalf("got error creating stream: %v", err)
+		}
+		stop := make(chan struct{})
+		go s.follow(path, NewPublisher(s), stop)
+
+		time.Sleep(50 * time.Millisecond)
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			t.Fatalf("got error opening %v: %v", path, err)
+		}
+		file.WriteString("link down\n")
+		file.Close()
+
+		select {
+		case ev := <-s.lines:
+			if ev.Line != "link down" {
+				t.Errorf("expected %q, got %+v", "link down", ev)
+			}
+		case <-time.After(2 * time.Second):
+			t.Errorf("timed out waiting for a line with watch %v", test.watch)
+		}
+		close(stop)
+	}
+}
+
+func TestPollFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "streammon")
+	if err != nil {
+		t.Fatalf("got error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A directory that can't be watched with inotify is polled.
+	testTable := []struct {
+		path  string
+		watch Watch
+		exp   bool
+	}{
+		{path: filepath.Join(dir, "messages"), watch: WatchPoll, exp: true},
+		{path: filepath.Join(dir, "missing", "messages"), watch: WatchInotify, exp: runtime.GOOS == "linux"},
+	}
+
+	for _, test := range testTable {
+		if resp := pollFile(test.path, test.watch); resp != test.exp {
+			t.Errorf("polling %v was incorrect, expected %v, got %v", test.path, test.exp, resp)
+		}
+	}
+}